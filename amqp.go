@@ -5,21 +5,18 @@
 package gocelery
 
 import (
-	"log"
-
 	"github.com/streadway/amqp"
 )
 
 // deliveryAck acknowledges delivery message with retries on error
-func deliveryAck(delivery amqp.Delivery) {
-	retryCount := 3
+func deliveryAck(delivery amqp.Delivery, logger Logger) {
 	var err error
-	for retryCount > 0 {
+	for retryCount := 3; retryCount > 0; retryCount-- {
 		if err = delivery.Ack(false); err == nil {
 			break
 		}
 	}
 	if err != nil {
-		log.Printf("amqp_backend: failed to acknowledge result message %+v: %+v", delivery.MessageId, err)
+		logger.Error("failed to acknowledge message", "message_id", delivery.MessageId, "error", err)
 	}
 }
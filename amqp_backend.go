@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
@@ -16,8 +17,10 @@ import (
 // AMQPCeleryBackend CeleryBackend for AMQP
 type AMQPCeleryBackend struct {
 	*amqp.Channel
-	connection *amqp.Connection
-	host       string
+	connection   *amqp.Connection
+	host         string
+	settingsLock sync.RWMutex
+	logger       Logger
 }
 
 // NewAMQPCeleryBackendByConnAndChannel creates new AMQPCeleryBackend by AMQP connection and channel
@@ -44,6 +47,20 @@ func (b *AMQPCeleryBackend) Reconnect() {
 	b.connection = conn
 }
 
+// SetLogger sets logger used to report failed acknowledgements
+func (b *AMQPCeleryBackend) SetLogger(logger Logger) {
+	b.settingsLock.Lock()
+	b.logger = logger
+	b.settingsLock.Unlock()
+}
+
+// getLogger returns logger of the backend
+func (b *AMQPCeleryBackend) getLogger() Logger {
+	b.settingsLock.RLock()
+	defer b.settingsLock.RUnlock()
+	return loggerOrDefault(b.logger)
+}
+
 // GetResult retrieves result from AMQP queue
 func (b *AMQPCeleryBackend) GetResult(ctx context.Context, taskID string) (*ResultMessage, error) {
 
@@ -63,19 +80,6 @@ func (b *AMQPCeleryBackend) GetResult(ctx context.Context, taskID string) (*Resu
 		return nil, err
 	}
 
-	err = b.ExchangeDeclare(
-		"default",
-		"direct",
-		true,
-		true,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
-
 	// open channel temporarily
 	channel, err := b.Consume(queueName, "", false, false, false, false, nil)
 	if err != nil {
@@ -85,7 +89,7 @@ func (b *AMQPCeleryBackend) GetResult(ctx context.Context, taskID string) (*Resu
 	var resultMessage ResultMessage
 
 	delivery := <-channel
-	deliveryAck(delivery)
+	deliveryAck(delivery, b.getLogger())
 	if err := json.Unmarshal(delivery.Body, &resultMessage); err != nil {
 		return nil, err
 	}
@@ -116,19 +120,6 @@ func (b *AMQPCeleryBackend) SetResult(ctx context.Context, taskID string, result
 		return err
 	}
 
-	err = b.ExchangeDeclare(
-		"default",
-		"direct",
-		true,
-		true,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return err
-	}
-
 	resBytes, err := json.Marshal(result)
 	if err != nil {
 		return err
@@ -6,19 +6,23 @@ package gocelery
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
 )
 
 // AMQPExchange stores AMQP Exchange configuration
+// Type is one of direct, topic, fanout or headers.
 type AMQPExchange struct {
 	Name       string
 	Type       string
 	Durable    bool
 	AutoDelete bool
+	Args       amqp.Table
 }
 
 // NewAMQPExchange creates new AMQPExchange
@@ -32,10 +36,18 @@ func NewAMQPExchange(name string) *AMQPExchange {
 }
 
 // AMQPQueue stores AMQP Queue configuration
+// Optional fields are declared as corresponding x-arguments in addition to Args.
 type AMQPQueue struct {
-	Name       string
-	Durable    bool
-	AutoDelete bool
+	Name                 string
+	Durable              bool
+	AutoDelete           bool
+	MessageTTL           time.Duration // x-message-ttl
+	MaxLength            int           // x-max-length
+	DeadLetterExchange   string        // x-dead-letter-exchange
+	DeadLetterRoutingKey string        // x-dead-letter-routing-key
+	Quorum               bool          // x-queue-type=quorum
+	MaxPriority          uint8         // x-max-priority
+	Args                 amqp.Table
 }
 
 // NewAMQPQueue creates new AMQPQueue
@@ -54,7 +66,16 @@ type AMQPCeleryBroker struct {
 	exchange         *AMQPExchange
 	queue            *AMQPQueue
 	consumingChannel <-chan amqp.Delivery
+	consumeLock      sync.Mutex
 	rate             int
+	settingsLock     sync.RWMutex
+	logger           Logger
+	deadLetters      DeadLetterQueue
+	topology         *AMQPTopology
+	declareLock      sync.Mutex
+	declaredQueues   map[string]bool
+	unackedLock      sync.Mutex
+	unacked          map[*TaskMessage]amqp.Delivery
 }
 
 // NewAMQPConnection creates new AMQP channel
@@ -78,69 +99,103 @@ func NewAMQPCeleryBroker(host string) *AMQPCeleryBroker {
 
 // NewAMQPCeleryBrokerByConnAndChannel creates new AMQPCeleryBroker using AMQP conn and channel
 func NewAMQPCeleryBrokerByConnAndChannel(conn *amqp.Connection, channel *amqp.Channel) *AMQPCeleryBroker {
+	broker, err := NewAMQPCeleryBrokerWithTopology(conn, channel, nil, "celery")
+	if err != nil {
+		panic(err)
+	}
+	return broker
+}
+
+// NewAMQPCeleryBrokerWithTopology creates new AMQPCeleryBroker consuming given queue
+// after declaring topology once. Queue is declared with default configuration
+// unless topology describes it.
+func NewAMQPCeleryBrokerWithTopology(conn *amqp.Connection, channel *amqp.Channel, topology *AMQPTopology, queue string) (*AMQPCeleryBroker, error) {
 	broker := &AMQPCeleryBroker{
-		Channel:    channel,
-		connection: conn,
-		exchange:   NewAMQPExchange("default"),
-		queue:      NewAMQPQueue("celery"),
-		rate:       4,
+		Channel:        channel,
+		connection:     conn,
+		exchange:       NewAMQPExchange("default"),
+		queue:          NewAMQPQueue(queue),
+		rate:           4,
+		topology:       topology,
+		declaredQueues: map[string]bool{},
 	}
 	if err := broker.CreateExchange(); err != nil {
-		panic(err)
+		return nil, err
 	}
-	if err := broker.CreateQueue(); err != nil {
-		panic(err)
+	if topology != nil {
+		if err := topology.Declare(channel); err != nil {
+			return nil, err
+		}
+		for _, q := range topology.Queues {
+			broker.declaredQueues[q.Name] = true
+		}
 	}
-	if err := broker.Qos(broker.rate, 0, false); err != nil {
-		panic(err)
+	if q := topology.queue(queue); q != nil {
+		broker.queue = q
+	} else if err := broker.CreateQueue(); err != nil {
+		return nil, err
 	}
-	if err := broker.StartConsumingChannel(); err != nil {
-		panic(err)
+	broker.declaredQueues[queue] = true
+	if err := broker.Qos(broker.rate, 0, false); err != nil {
+		return nil, err
 	}
-	return broker
+	return broker, nil
+}
+
+// amqpConsumer is part of AMQP channel configuring and starting consumers
+type amqpConsumer interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
 }
 
 // StartConsumingChannel spawns receiving channel on AMQP queue
+// Consuming starts on first received message otherwise, so that prefetch count
+// set by SetPrefetchCount applies to the consumer.
 func (b *AMQPCeleryBroker) StartConsumingChannel() error {
-	channel, err := b.Consume(b.queue.Name, "", false, false, false, false, nil)
+	_, err := b.deliveries(b.Channel)
+	return err
+}
+
+// deliveries returns receiving channel on AMQP queue starting consumer unless it is running
+func (b *AMQPCeleryBroker) deliveries(ch amqpConsumer) (<-chan amqp.Delivery, error) {
+	b.consumeLock.Lock()
+	defer b.consumeLock.Unlock()
+	if b.consumingChannel != nil {
+		return b.consumingChannel, nil
+	}
+	channel, err := ch.Consume(b.queue.Name, "", false, false, false, false, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	b.consumingChannel = channel
-	return nil
+	return channel, nil
 }
 
 // SendCeleryMessage sends CeleryMessage to broker
 func (b *AMQPCeleryBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
-	taskMessage := message.GetTaskMessage(ctx, timeout)
-	queueName := "celery"
-
-	if rk := message.Properties.DeliveryInfo.RoutingKey; rk != `` {
-		queueName = rk
-	}
-
-	_, err := b.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // autoDelete
-		false,     // exclusive
-		false,     // noWait
-		nil,       // args
-	)
+	taskMessage, err := message.decodeTaskMessage()
 	if err != nil {
 		return err
 	}
-	err = b.ExchangeDeclare(
-		"default",
-		"direct",
-		true,
-		true,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return err
+	deliveryInfo := message.Properties.DeliveryInfo
+	routingKey := "celery"
+	if deliveryInfo.RoutingKey != `` {
+		routingKey = deliveryInfo.RoutingKey
+	}
+	exchange := deliveryInfo.Exchange
+	if exchange == celeryExchange {
+		exchange = ``
+	}
+
+	// routing to named exchange relies on declared bindings,
+	// queue bound in topology is reached through its exchange,
+	// otherwise default exchange delivers to queue named by routing key
+	if exchange == `` {
+		if boundExchange, boundKey, ok := b.topology.route(routingKey); ok {
+			exchange, routingKey = boundExchange, boundKey
+		} else if err := b.declareRoutingQueue(routingKey); err != nil {
+			return err
+		}
 	}
 
 	resBytes, err := json.Marshal(taskMessage)
@@ -149,58 +204,270 @@ func (b *AMQPCeleryBroker) SendCeleryMessage(ctx context.Context, timeout time.D
 	}
 
 	publishMessage := amqp.Publishing{
+		Headers:      amqp.Table(message.Headers),
 		DeliveryMode: amqp.Persistent,
+		Priority:     uint8(deliveryInfo.Priority),
 		Timestamp:    time.Now(),
 		ContentType:  "application/json",
 		Body:         resBytes,
 	}
 
 	return b.Publish(
-		"",
-		queueName,
+		exchange,
+		routingKey,
 		false,
 		false,
 		publishMessage,
 	)
 }
 
+// celeryExchange is exchange of default celery delivery info
+// It routes by queue name, therefore it is published as AMQP default exchange.
+const celeryExchange = "celery"
+
+// declareRoutingQueue declares queue to publish task messages to unless it was already declared
+func (b *AMQPCeleryBroker) declareRoutingQueue(queueName string) error {
+	b.declareLock.Lock()
+	defer b.declareLock.Unlock()
+	if b.declaredQueues[queueName] {
+		return nil
+	}
+	if err := NewAMQPQueue(queueName).declare(b.Channel); err != nil {
+		return err
+	}
+	if b.declaredQueues == nil {
+		b.declaredQueues = map[string]bool{}
+	}
+	b.declaredQueues[queueName] = true
+	return nil
+}
+
 // GetTaskMessage retrieves task message from AMQP queue
+// Delivery is acknowledged once received, see ConsumeTaskMessages for acknowledgement after processing.
 func (b *AMQPCeleryBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	consumingChannel, err := b.deliveries(b.Channel)
+	if err != nil {
+		return nil, err
+	}
 	select {
-	case delivery := <-b.consumingChannel:
-		deliveryAck(delivery)
-		var taskMessage TaskMessage
-		if err := json.Unmarshal(delivery.Body, &taskMessage); err != nil {
+	case delivery := <-consumingChannel:
+		deliveryAck(delivery, b.getLogger())
+		taskMessage, err := taskMessageFromDelivery(delivery)
+		if err != nil {
+			b.deadLetter(ctx, delivery, err)
 			return nil, err
 		}
-		return &taskMessage, nil
+		return taskMessage, nil
 	default:
 		return nil, fmt.Errorf("consuming channel is empty")
 	}
 }
 
+// ConsumeTaskMessages streams task messages from AMQP queue
+// Deliveries stay unacknowledged until AckTaskMessage or NackTaskMessage is called,
+// therefore AMQP server redelivers messages of consumer stopped before processing them.
+// Unacknowledged messages, including messages held until ETA, count against prefetch count.
+// Delivery pending when ctx is done is rejected back to queue.
+func (b *AMQPCeleryBroker) ConsumeTaskMessages(ctx context.Context, timeout time.Duration) (<-chan *TaskMessage, error) {
+	consumingChannel, err := b.deliveries(b.Channel)
+	if err != nil {
+		return nil, err
+	}
+	taskMessages := make(chan *TaskMessage)
+	go func() {
+		defer close(taskMessages)
+		logger := b.getLogger()
+		for {
+			var delivery amqp.Delivery
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case delivery, ok = <-consumingChannel:
+				if !ok {
+					return
+				}
+			}
+			taskMessage, err := taskMessageFromDelivery(delivery)
+			if err != nil {
+				b.deadLetter(ctx, delivery, err)
+				deliveryAck(delivery, logger)
+				continue
+			}
+			b.holdDelivery(taskMessage, delivery)
+			if ctx.Err() == nil {
+				select {
+				case taskMessages <- taskMessage:
+					continue
+				case <-ctx.Done():
+				}
+			}
+			if err := b.NackTaskMessage(context.WithoutCancel(ctx), taskMessage, true); err != nil {
+				logger.Error("failed to requeue message", "message_id", delivery.MessageId, "error", err)
+			}
+			return
+		}
+	}()
+	return taskMessages, nil
+}
+
+// AckTaskMessage acknowledges delivery of message received from ConsumeTaskMessages
+func (b *AMQPCeleryBroker) AckTaskMessage(ctx context.Context, message *TaskMessage) error {
+	delivery, err := b.takeDelivery(message)
+	if err != nil {
+		return err
+	}
+	return delivery.Ack(false)
+}
+
+// NackTaskMessage rejects delivery of message received from ConsumeTaskMessages
+// Message is returned to its queue if requeue is set, otherwise it is dropped
+// or dead-lettered by AMQP server.
+func (b *AMQPCeleryBroker) NackTaskMessage(ctx context.Context, message *TaskMessage, requeue bool) error {
+	delivery, err := b.takeDelivery(message)
+	if err != nil {
+		return err
+	}
+	return delivery.Nack(false, requeue)
+}
+
+// holdDelivery keeps delivery of message until message is acknowledged or rejected
+func (b *AMQPCeleryBroker) holdDelivery(message *TaskMessage, delivery amqp.Delivery) {
+	b.unackedLock.Lock()
+	defer b.unackedLock.Unlock()
+	if b.unacked == nil {
+		b.unacked = map[*TaskMessage]amqp.Delivery{}
+	}
+	b.unacked[message] = delivery
+}
+
+// takeDelivery removes delivery of message from unacknowledged deliveries
+func (b *AMQPCeleryBroker) takeDelivery(message *TaskMessage) (amqp.Delivery, error) {
+	b.unackedLock.Lock()
+	defer b.unackedLock.Unlock()
+	delivery, ok := b.unacked[message]
+	if !ok {
+		return amqp.Delivery{}, fmt.Errorf("task %s is not awaiting acknowledgement", message.ID)
+	}
+	delete(b.unacked, message)
+	return delivery, nil
+}
+
+// SetDeadLetterQueue sets queue receiving messages which cannot be decoded
+func (b *AMQPCeleryBroker) SetDeadLetterQueue(dlq DeadLetterQueue) {
+	b.settingsLock.Lock()
+	b.deadLetters = dlq
+	b.settingsLock.Unlock()
+}
+
+// getDeadLetterQueue returns queue receiving messages which cannot be decoded
+func (b *AMQPCeleryBroker) getDeadLetterQueue() DeadLetterQueue {
+	b.settingsLock.RLock()
+	defer b.settingsLock.RUnlock()
+	return b.deadLetters
+}
+
+// deadLetter reports undecodable delivery and stores it in dead letter queue
+// wrapped in celery message addressed to queue it was delivered from
+func (b *AMQPCeleryBroker) deadLetter(ctx context.Context, delivery amqp.Delivery, err error) {
+	queue := delivery.RoutingKey
+	if queue == "" {
+		queue = b.queue.Name
+	}
+	logger := b.getLogger().With("queue", queue)
+	logger.Error("failed to decode task message", "error", err)
+	dlq := b.getDeadLetterQueue()
+	if dlq == nil {
+		return
+	}
+	celeryMessage := getCeleryMessage(base64.StdEncoding.EncodeToString(delivery.Body))
+	defer releaseCeleryMessage(celeryMessage)
+	if len(delivery.Headers) > 0 {
+		celeryMessage.Headers = map[string]interface{}(delivery.Headers)
+	}
+	celeryMessage.Properties.DeliveryInfo = CeleryDeliveryInfo{
+		Priority:   int(delivery.Priority),
+		RoutingKey: queue,
+		Exchange:   delivery.Exchange,
+	}
+	body, _ := json.Marshal(celeryMessage)
+	putDeadLetter(ctx, dlq, logger, newDeadLetter(DeadLetterDecodeError, err, queue, body))
+}
+
+// taskMessageFromDelivery decodes task message carried by AMQP delivery
+func taskMessageFromDelivery(delivery amqp.Delivery) (*TaskMessage, error) {
+	var taskMessage TaskMessage
+	if err := json.Unmarshal(delivery.Body, &taskMessage); err != nil {
+		return nil, err
+	}
+	if len(delivery.Headers) > 0 {
+		taskMessage.Headers = map[string]interface{}(delivery.Headers)
+	}
+	taskMessage.DeliveryInfo = &CeleryDeliveryInfo{
+		Priority:   int(delivery.Priority),
+		RoutingKey: delivery.RoutingKey,
+		Exchange:   delivery.Exchange,
+	}
+	return &taskMessage, nil
+}
+
+// SetPrefetchCount sets number of unacknowledged messages delivered by AMQP server
+// AMQP applies prefetch count to consumers started afterwards, therefore
+// it must be set before first message is received.
+func (b *AMQPCeleryBroker) SetPrefetchCount(count int) error {
+	return b.setPrefetchCount(b.Channel, count)
+}
+
+func (b *AMQPCeleryBroker) setPrefetchCount(ch amqpConsumer, count int) error {
+	if count < 1 {
+		return fmt.Errorf("invalid prefetch count %d", count)
+	}
+	b.consumeLock.Lock()
+	defer b.consumeLock.Unlock()
+	if count == b.rate {
+		return nil
+	}
+	if b.consumingChannel != nil {
+		return fmt.Errorf("prefetch count cannot be changed after consuming started")
+	}
+	if err := ch.Qos(count, 0, false); err != nil {
+		return err
+	}
+	b.rate = count
+	return nil
+}
+
+// SetLogger sets logger used to report failed acknowledgements
+func (b *AMQPCeleryBroker) SetLogger(logger Logger) {
+	b.settingsLock.Lock()
+	b.logger = logger
+	b.settingsLock.Unlock()
+}
+
+// getLogger returns logger of the broker
+func (b *AMQPCeleryBroker) getLogger() Logger {
+	b.settingsLock.RLock()
+	defer b.settingsLock.RUnlock()
+	return loggerOrDefault(b.logger)
+}
+
 // CreateExchange declares AMQP exchange with stored configuration
 func (b *AMQPCeleryBroker) CreateExchange() error {
-	return b.ExchangeDeclare(
-		b.exchange.Name,
-		b.exchange.Type,
-		b.exchange.Durable,
-		b.exchange.AutoDelete,
-		false,
-		false,
-		nil,
-	)
+	return b.exchange.declare(b.Channel)
 }
 
 // CreateQueue declares AMQP Queue with stored configuration
 func (b *AMQPCeleryBroker) CreateQueue() error {
-	_, err := b.QueueDeclare(
-		b.queue.Name,
-		b.queue.Durable,
-		b.queue.AutoDelete,
-		false,
-		false,
-		nil,
-	)
-	return err
+	return b.queue.declare(b.Channel)
+}
+
+// QueueDepth returns number of messages ready to be delivered from given queue
+// Messages delivered to consumers and awaiting acknowledgement, including messages
+// held until ETA, are not counted as AMQP server reports ready messages only.
+func (b *AMQPCeleryBroker) QueueDepth(ctx context.Context, queue string) (int64, error) {
+	q, err := b.QueueInspect(queue)
+	if err != nil {
+		return 0, err
+	}
+	return int64(q.Messages), nil
 }
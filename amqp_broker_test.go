@@ -0,0 +1,136 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// recordingConsumer records prefetch counts and consumers set on AMQP channel
+type recordingConsumer struct {
+	calls []string
+}
+
+func (c *recordingConsumer) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.calls = append(c.calls, fmt.Sprintf("qos %d global=%v", prefetchCount, global))
+	return nil
+}
+
+func (c *recordingConsumer) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.calls = append(c.calls, "consume "+queue)
+	return make(chan amqp.Delivery), nil
+}
+
+// TestAMQPBrokerPrefetchCount tests that prefetch count is applied before consumer starts
+func TestAMQPBrokerPrefetchCount(t *testing.T) {
+	ch := &recordingConsumer{}
+	broker := &AMQPCeleryBroker{queue: NewAMQPQueue("celery"), rate: 4}
+
+	if err := broker.setPrefetchCount(ch, 12); err != nil {
+		t.Fatalf("failed to set prefetch count: %v", err)
+	}
+	first, err := broker.deliveries(ch)
+	if err != nil {
+		t.Fatalf("failed to start consuming: %v", err)
+	}
+	if second, _ := broker.deliveries(ch); second != first {
+		t.Errorf("running consumer should be reused")
+	}
+	if err := broker.setPrefetchCount(ch, 12); err != nil {
+		t.Errorf("setting unchanged prefetch count should succeed: %v", err)
+	}
+	if err := broker.setPrefetchCount(ch, 2); err == nil {
+		t.Errorf("changing prefetch count of running consumer should fail")
+	}
+	expected := []string{"qos 12 global=false", "consume celery"}
+	if !reflect.DeepEqual(ch.calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, ch.calls)
+	}
+}
+
+// recordingAcknowledger records acknowledgements of AMQP deliveries
+type recordingAcknowledger struct {
+	sync.Mutex
+	calls []string
+}
+
+func (a *recordingAcknowledger) record(call string) error {
+	a.Lock()
+	defer a.Unlock()
+	a.calls = append(a.calls, call)
+	return nil
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.record(fmt.Sprintf("ack %d", tag))
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.record(fmt.Sprintf("nack %d requeue=%v", tag, requeue))
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.record(fmt.Sprintf("reject %d requeue=%v", tag, requeue))
+}
+
+func (a *recordingAcknowledger) recorded() []string {
+	a.Lock()
+	defer a.Unlock()
+	return append([]string(nil), a.calls...)
+}
+
+// TestAMQPBrokerAcknowledgement tests that deliveries are acknowledged after processing, not on hand-off
+func TestAMQPBrokerAcknowledgement(t *testing.T) {
+	acknowledger := &recordingAcknowledger{}
+	deliveries := make(chan amqp.Delivery, 3)
+	for tag := uint64(1); tag <= 3; tag++ {
+		deliveries <- amqp.Delivery{
+			Acknowledger: acknowledger,
+			DeliveryTag:  tag,
+			RoutingKey:   "celery",
+			Body:         []byte(fmt.Sprintf(`{"id": "%d", "task": "add", "args": [1, 2]}`, tag)),
+		}
+	}
+	broker := &AMQPCeleryBroker{queue: NewAMQPQueue("celery"), consumingChannel: deliveries}
+	broker.SetLogger(NopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	taskMessages, err := broker.ConsumeTaskMessages(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to consume: %v", err)
+	}
+	first := <-taskMessages
+	if calls := acknowledger.recorded(); len(calls) != 0 {
+		t.Errorf("delivery should not be acknowledged on hand-off, got %v", calls)
+	}
+	if err := broker.AckTaskMessage(ctx, first); err != nil {
+		t.Errorf("failed to acknowledge message: %v", err)
+	}
+	if err := broker.AckTaskMessage(ctx, first); err == nil {
+		t.Errorf("acknowledged message should not be acknowledged again")
+	}
+	second := <-taskMessages
+	if err := broker.NackTaskMessage(ctx, second, true); err != nil {
+		t.Errorf("failed to reject message: %v", err)
+	}
+
+	// third delivery is pending until consumer stops and is returned to queue
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	for range taskMessages {
+	}
+	expected := []string{"ack 1", "nack 2 requeue=true", "nack 3 requeue=true"}
+	if calls := acknowledger.recorded(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
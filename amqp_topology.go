@@ -0,0 +1,152 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// AMQPBinding stores AMQP binding of queue to exchange
+type AMQPBinding struct {
+	Queue      string
+	Exchange   string
+	RoutingKey string
+	Args       amqp.Table
+}
+
+// AMQPTopology describes exchanges, queues and bindings declared at startup
+// Tasks routed to queue bound to an exchange are published through that exchange.
+type AMQPTopology struct {
+	Exchanges []*AMQPExchange
+	Queues    []*AMQPQueue
+	Bindings  []*AMQPBinding
+}
+
+// amqpDeclarer declares AMQP entities, it is implemented by *amqp.Channel
+type amqpDeclarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// Declare declares exchanges, queues and bindings of topology in this order
+func (t *AMQPTopology) Declare(channel *amqp.Channel) error {
+	return t.declare(channel)
+}
+
+func (t *AMQPTopology) declare(declarer amqpDeclarer) error {
+	for _, exchange := range t.Exchanges {
+		if err := exchange.declare(declarer); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %w", exchange.Name, err)
+		}
+	}
+	for _, queue := range t.Queues {
+		if err := queue.declare(declarer); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queue.Name, err)
+		}
+	}
+	for _, binding := range t.Bindings {
+		if err := declarer.QueueBind(binding.Queue, binding.RoutingKey, binding.Exchange, false, binding.Args); err != nil {
+			return fmt.Errorf("failed to bind queue %s to exchange %s: %w", binding.Queue, binding.Exchange, err)
+		}
+	}
+	return nil
+}
+
+// queue returns queue of topology with given name
+func (t *AMQPTopology) queue(name string) *AMQPQueue {
+	if t == nil {
+		return nil
+	}
+	for _, queue := range t.Queues {
+		if queue.Name == name {
+			return queue
+		}
+	}
+	return nil
+}
+
+// route returns exchange and routing key delivering messages to given queue
+// Queues bound only to headers exchanges cannot be routed by routing key.
+func (t *AMQPTopology) route(queue string) (exchange, routingKey string, ok bool) {
+	if t == nil {
+		return "", "", false
+	}
+	for _, binding := range t.Bindings {
+		if binding.Queue != queue {
+			continue
+		}
+		kind := amqp.ExchangeDirect
+		for _, exchange := range t.Exchanges {
+			if exchange.Name == binding.Exchange {
+				kind = exchange.Type
+			}
+		}
+		if kind == amqp.ExchangeHeaders {
+			continue
+		}
+		return binding.Exchange, binding.RoutingKey, true
+	}
+	return "", "", false
+}
+
+// declare declares exchange with stored configuration
+func (e *AMQPExchange) declare(declarer amqpDeclarer) error {
+	return declarer.ExchangeDeclare(
+		e.Name,
+		e.Type,
+		e.Durable,
+		e.AutoDelete,
+		false,
+		false,
+		e.Args,
+	)
+}
+
+// declare declares queue with stored configuration
+func (q *AMQPQueue) declare(declarer amqpDeclarer) error {
+	_, err := declarer.QueueDeclare(
+		q.Name,
+		q.Durable,
+		q.AutoDelete,
+		false,
+		false,
+		q.arguments(),
+	)
+	return err
+}
+
+// arguments returns x-arguments of queue
+func (q *AMQPQueue) arguments() amqp.Table {
+	args := amqp.Table{}
+	for key, value := range q.Args {
+		args[key] = value
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = int64(q.MessageTTL / time.Millisecond)
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = int64(q.MaxLength)
+	}
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	if q.Quorum {
+		args["x-queue-type"] = "quorum"
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = int64(q.MaxPriority)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// recordingDeclarer records AMQP declarations
+type recordingDeclarer struct {
+	calls []string
+	args  map[string]amqp.Table
+}
+
+func (d *recordingDeclarer) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	d.calls = append(d.calls, fmt.Sprintf("exchange %s %s", name, kind))
+	return nil
+}
+
+func (d *recordingDeclarer) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	d.calls = append(d.calls, fmt.Sprintf("queue %s", name))
+	d.args[name] = args
+	return amqp.Queue{Name: name}, nil
+}
+
+func (d *recordingDeclarer) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	d.calls = append(d.calls, fmt.Sprintf("bind %s %s %s", name, exchange, key))
+	return nil
+}
+
+var testTopology = &AMQPTopology{
+	Exchanges: []*AMQPExchange{
+		{Name: "media", Type: amqp.ExchangeTopic, Durable: true},
+		{Name: "dlx", Type: amqp.ExchangeFanout, Durable: true},
+		{Name: "match", Type: amqp.ExchangeHeaders, Durable: true},
+	},
+	Queues: []*AMQPQueue{
+		{
+			Name:               "video",
+			Durable:            true,
+			MessageTTL:         time.Minute,
+			MaxLength:          1000,
+			DeadLetterExchange: "dlx",
+			MaxPriority:        10,
+		},
+		{Name: "dead", Durable: true, Quorum: true, Args: amqp.Table{"x-delivery-limit": int64(5)}},
+		{Name: "filtered", Durable: true},
+	},
+	Bindings: []*AMQPBinding{
+		{Queue: "video", Exchange: "media", RoutingKey: "media.video.*"},
+		{Queue: "dead", Exchange: "dlx"},
+		{Queue: "filtered", Exchange: "match", Args: amqp.Table{"x-match": "all"}},
+	},
+}
+
+// TestAMQPTopologyDeclare tests order and arguments of topology declarations
+func TestAMQPTopologyDeclare(t *testing.T) {
+	declarer := &recordingDeclarer{args: map[string]amqp.Table{}}
+	if err := testTopology.declare(declarer); err != nil {
+		t.Fatalf("failed to declare topology: %v", err)
+	}
+	expectedCalls := []string{
+		"exchange media topic",
+		"exchange dlx fanout",
+		"exchange match headers",
+		"queue video",
+		"queue dead",
+		"queue filtered",
+		"bind video media media.video.*",
+		"bind dead dlx ",
+		"bind filtered match ",
+	}
+	if !reflect.DeepEqual(declarer.calls, expectedCalls) {
+		t.Errorf("expected declarations %v, got %v", expectedCalls, declarer.calls)
+	}
+	expectedArgs := map[string]amqp.Table{
+		"video": {
+			"x-message-ttl":          int64(60000),
+			"x-max-length":           int64(1000),
+			"x-dead-letter-exchange": "dlx",
+			"x-max-priority":         int64(10),
+		},
+		"dead": {
+			"x-queue-type":     "quorum",
+			"x-delivery-limit": int64(5),
+		},
+		"filtered": nil,
+	}
+	if !reflect.DeepEqual(declarer.args, expectedArgs) {
+		t.Errorf("expected queue arguments %v, got %v", expectedArgs, declarer.args)
+	}
+}
+
+// TestAMQPTopologyRoute tests routing to queues through bound exchanges
+func TestAMQPTopologyRoute(t *testing.T) {
+	testCases := []struct {
+		name       string
+		queue      string
+		exchange   string
+		routingKey string
+		ok         bool
+	}{
+		{name: "topic binding", queue: "video", exchange: "media", routingKey: "media.video.*", ok: true},
+		{name: "fanout binding", queue: "dead", exchange: "dlx", routingKey: "", ok: true},
+		{name: "headers binding", queue: "filtered"},
+		{name: "unknown queue", queue: "celery"},
+	}
+	for _, tc := range testCases {
+		exchange, routingKey, ok := testTopology.route(tc.queue)
+		if exchange != tc.exchange || routingKey != tc.routingKey || ok != tc.ok {
+			t.Errorf("test '%s': expected route (%q, %q, %v), got (%q, %q, %v)", tc.name, tc.exchange, tc.routingKey, tc.ok, exchange, routingKey, ok)
+		}
+	}
+	var nilTopology *AMQPTopology
+	if _, _, ok := nilTopology.route("video"); ok {
+		t.Errorf("nil topology should not route")
+	}
+}
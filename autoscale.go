@@ -0,0 +1,161 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"time"
+)
+
+// Autoscale configures scaling of worker goroutines based on load
+// Load is number of running tasks and messages waiting in queue,
+// similar to --autoscale option of celery worker.
+type Autoscale struct {
+	Min         int            // minimum number of worker goroutines, at least 1
+	Max         int            // maximum number of worker goroutines
+	Interval    time.Duration  // how often load is checked, defaults to 1s
+	IdleTimeout time.Duration  // how long load must stay low before shrinking, defaults to 30s
+	Queue       string         // inspected queue, defaults to "celery"
+	Inspector   QueueInspector // defaults to broker if it implements QueueInspector
+}
+
+// SetAutoscale enables autoscaling of worker goroutines between min and max
+// It must be called before workers are started. At least one goroutine is kept,
+// because messages already taken from broker are not visible in queue depth.
+func (w *CeleryWorker) SetAutoscale(autoscale Autoscale) {
+	if autoscale.Max < 1 {
+		autoscale.Max = 1
+	}
+	if autoscale.Min < 1 {
+		autoscale.Min = 1
+	}
+	if autoscale.Min > autoscale.Max {
+		autoscale.Min = autoscale.Max
+	}
+	if autoscale.Interval <= 0 {
+		autoscale.Interval = time.Second
+	}
+	if autoscale.IdleTimeout <= 0 {
+		autoscale.IdleTimeout = 30 * time.Second
+	}
+	if autoscale.Queue == "" {
+		autoscale.Queue = "celery"
+	}
+	if autoscale.Inspector == nil {
+		autoscale.Inspector, _ = w.broker.(QueueInspector)
+	}
+
+	w.poolLock.Lock()
+	defer w.poolLock.Unlock()
+	w.autoscale = &autoscale
+	w.numWorkers = clampWorkers(w.numWorkers, autoscale.Min, autoscale.Max)
+}
+
+// Grow adds n worker goroutines without exceeding autoscale maximum
+func (w *CeleryWorker) Grow(n int) {
+	w.poolLock.Lock()
+	defer w.poolLock.Unlock()
+	w.growLocked(n)
+}
+
+// Shrink stops n worker goroutines without going below autoscale minimum
+// Stopped goroutines finish task they are running.
+func (w *CeleryWorker) Shrink(n int) {
+	w.poolLock.Lock()
+	defer w.poolLock.Unlock()
+	minWorkers := 0
+	if w.autoscale != nil {
+		minWorkers = w.autoscale.Min
+	}
+	for ; n > 0 && w.numWorkers > minWorkers; n-- {
+		if last := len(w.pool) - 1; last >= 0 {
+			close(w.pool[last])
+			w.pool = w.pool[:last]
+		}
+		w.numWorkers--
+	}
+}
+
+// growLocked adds n worker goroutines, starting them if workers are running
+func (w *CeleryWorker) growLocked(n int) {
+	for ; n > 0; n-- {
+		if w.autoscale != nil && w.numWorkers >= w.autoscale.Max {
+			return
+		}
+		w.numWorkers++
+		if w.wctx == nil || w.wctx.Err() != nil {
+			continue
+		}
+		stop := make(chan struct{})
+		w.pool = append(w.pool, stop)
+		w.workWG.Add(1)
+		go w.work(w.wctx, w.runCtx, w.nextWorkerID, stop, w.deliveries, w.due)
+		w.nextWorkerID++
+	}
+}
+
+// autoscaleLoop periodically scales worker goroutines to match load
+func (w *CeleryWorker) autoscaleLoop(ctx context.Context, autoscale Autoscale) {
+	defer w.workWG.Done()
+	ticker := time.NewTicker(autoscale.Interval)
+	defer ticker.Stop()
+	var lowSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			lowSince = w.scale(ctx, autoscale, lowSince, now)
+		}
+	}
+}
+
+// scale grows worker goroutines to match load, or shrinks them once load
+// stayed below number of workers for IdleTimeout since lowSince.
+// It returns time since which load is low, zero time if it is not.
+func (w *CeleryWorker) scale(ctx context.Context, autoscale Autoscale, lowSince, now time.Time) time.Time {
+	current := w.GetNumWorkers()
+	target := clampWorkers(w.load(ctx, autoscale), autoscale.Min, autoscale.Max)
+	switch {
+	case target > current:
+		w.Grow(target - current)
+	case target < current:
+		if lowSince.IsZero() {
+			return now
+		}
+		if now.Sub(lowSince) < autoscale.IdleTimeout {
+			return lowSince
+		}
+		w.Shrink(current - target)
+	}
+	return time.Time{}
+}
+
+// load returns number of running tasks and messages waiting in queue
+func (w *CeleryWorker) load(ctx context.Context, autoscale Autoscale) int {
+	w.inFlightLock.Lock()
+	load := len(w.inFlight)
+	w.inFlightLock.Unlock()
+	if autoscale.Inspector == nil {
+		return load
+	}
+	depth, err := autoscale.Inspector.QueueDepth(ctx, autoscale.Queue)
+	if err != nil {
+		w.getLogger().Warn("failed to inspect queue depth", "queue", autoscale.Queue, "error", err)
+		return load
+	}
+	return load + int(depth)
+}
+
+// clampWorkers limits number of workers to range between min and max
+func clampWorkers(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
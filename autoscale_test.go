@@ -0,0 +1,170 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestWorkerGrowShrink tests resizing worker pool within autoscale bounds
+func TestWorkerGrowShrink(t *testing.T) {
+	broker := &queueBroker{}
+	backend := NewMemoryCeleryBackend()
+	worker := NewCeleryWorker(broker, backend, 6)
+	worker.SetAutoscale(Autoscale{Min: 2, Max: 4, Interval: time.Hour})
+	if n := worker.GetNumWorkers(); n != 4 {
+		t.Errorf("expected number of workers clamped to 4, got %d", n)
+	}
+	worker.StartWorker(context.Background(), TIMEOUT)
+	defer worker.StopWorker()
+
+	testCases := []struct {
+		name     string
+		resize   func(n int)
+		n        int
+		expected int
+	}{
+		{name: "shrink", resize: worker.Shrink, n: 1, expected: 3},
+		{name: "shrink below min", resize: worker.Shrink, n: 5, expected: 2},
+		{name: "grow", resize: worker.Grow, n: 1, expected: 3},
+		{name: "grow above max", resize: worker.Grow, n: 5, expected: 4},
+	}
+	for _, tc := range testCases {
+		tc.resize(tc.n)
+		if n := worker.GetNumWorkers(); n != tc.expected {
+			t.Errorf("test '%s': expected %d workers, got %d", tc.name, tc.expected, n)
+		}
+		worker.poolLock.Lock()
+		running := len(worker.pool)
+		worker.poolLock.Unlock()
+		if running != tc.expected {
+			t.Errorf("test '%s': expected %d running goroutines, got %d", tc.name, tc.expected, running)
+		}
+	}
+}
+
+// TestWorkerAutoscale tests scaling worker pool with queue depth and idle time
+func TestWorkerAutoscale(t *testing.T) {
+	broker := &queueBroker{}
+	backend := NewMemoryCeleryBackend()
+	cli, _ := NewCeleryClient(broker, backend, 1)
+	release := make(chan struct{})
+	cli.Register("block", func() string {
+		<-release
+		return "done"
+	})
+	cli.SetAutoscale(Autoscale{Min: 1, Max: 3, Interval: 5 * time.Millisecond, IdleTimeout: 50 * time.Millisecond})
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if _, err := cli.Delay(ctx, TIMEOUT, "block"); err != nil {
+			t.Fatalf("failed to send task: %v", err)
+		}
+	}
+	cli.StartWorker(ctx, TIMEOUT)
+	defer cli.StopWorker()
+
+	waitForWorkers := func(expected int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for cli.worker.GetNumWorkers() != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d workers, got %d", expected, cli.worker.GetNumWorkers())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForWorkers(3)
+	close(release)
+	waitForWorkers(1)
+	if queued := broker.queued(); len(queued) != 0 {
+		t.Errorf("expected all tasks to be processed, %d left in queue", len(queued))
+	}
+}
+
+// TestWorkerAutoscaleIdleTimeout tests that pool shrinks only after load stayed low for idle timeout
+func TestWorkerAutoscaleIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryCeleryBroker()
+	worker := NewCeleryWorker(broker, NewMemoryCeleryBackend(), 1)
+	worker.SetAutoscale(Autoscale{Min: 1, Max: 4, IdleTimeout: 30 * time.Second})
+	autoscale := *worker.autoscale
+
+	setDepth := func(depth int) {
+		for n, _ := broker.QueueDepth(ctx, "celery"); int(n) > depth; n-- {
+			if _, err := broker.GetTaskMessage(ctx, TIMEOUT); err != nil {
+				t.Fatalf("failed to get message: %v", err)
+			}
+		}
+		for n, _ := broker.QueueDepth(ctx, "celery"); int(n) < depth; n++ {
+			sendMemoryTask(t, broker, "task", nil, nil)
+		}
+	}
+
+	start := time.Now()
+	testCases := []struct {
+		name     string
+		depth    int
+		elapsed  time.Duration
+		expected int
+	}{
+		{name: "grow", depth: 4, elapsed: 0, expected: 4},
+		{name: "load drops long after growing", depth: 0, elapsed: time.Minute, expected: 4},
+		{name: "load stays low shorter than timeout", depth: 0, elapsed: 80 * time.Second, expected: 4},
+		{name: "load rises", depth: 4, elapsed: 85 * time.Second, expected: 4},
+		{name: "load drops again", depth: 0, elapsed: 100 * time.Second, expected: 4},
+		{name: "timeout counted since load dropped again", depth: 0, elapsed: 120 * time.Second, expected: 4},
+		{name: "shrink", depth: 0, elapsed: 130 * time.Second, expected: 1},
+	}
+	var lowSince time.Time
+	for _, tc := range testCases {
+		setDepth(tc.depth)
+		lowSince = worker.scale(ctx, autoscale, lowSince, start.Add(tc.elapsed))
+		if n := worker.GetNumWorkers(); n != tc.expected {
+			t.Errorf("test '%s': expected %d workers, got %d", tc.name, tc.expected, n)
+		}
+	}
+}
+
+// TestWorkerAutoscaleFromMin tests that pool with lowest minimum still processes tasks and grows
+func TestWorkerAutoscaleFromMin(t *testing.T) {
+	broker := NewMemoryCeleryBroker()
+	cli, _ := NewCeleryClient(broker, NewMemoryCeleryBackend(), 1)
+	release := make(chan struct{})
+	cli.Register("block", func() string {
+		<-release
+		return "done"
+	})
+	cli.SetAutoscale(Autoscale{Min: 0, Max: 3, Interval: 5 * time.Millisecond, IdleTimeout: time.Hour})
+	if n := cli.worker.GetNumWorkers(); n != 1 {
+		t.Errorf("expected minimum raised to 1 worker, got %d", n)
+	}
+
+	ctx := context.Background()
+	cli.StartWorker(ctx, TIMEOUT)
+	defer cli.StopWorker()
+	var results []*AsyncResult
+	for i := 0; i < 3; i++ {
+		asyncResult, err := cli.Delay(ctx, TIMEOUT, "block")
+		if err != nil {
+			t.Fatalf("failed to send task: %v", err)
+		}
+		results = append(results, asyncResult)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cli.worker.GetNumWorkers() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected pool to grow from minimum, got %d workers", cli.worker.GetNumWorkers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for _, asyncResult := range results {
+		if res, err := asyncResult.Get(ctx, TIMEOUT); err != nil || res != "done" {
+			t.Errorf("expected task result done, got %v (%v)", res, err)
+		}
+	}
+}
@@ -121,6 +121,10 @@ func TestBackendSetGetResult(t *testing.T) {
 			name:    "set/get result to amqp backend",
 			backend: amqpBackend,
 		},
+		{
+			name:    "set/get result to memory backend",
+			backend: NewMemoryCeleryBackend(),
+		},
 	}
 	for _, tc := range testCases {
 		ctx := context.Background()
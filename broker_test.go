@@ -131,6 +131,10 @@ func TestBrokerSendGet(t *testing.T) {
 			name:   "send/get task for amqp broker",
 			broker: amqpBroker,
 		},
+		{
+			name:   "send/get task for memory broker",
+			broker: NewMemoryCeleryBroker(),
+		},
 	}
 	for _, tc := range testCases {
 		ctx := context.Background()
@@ -154,6 +158,8 @@ func TestBrokerSendGet(t *testing.T) {
 			continue
 		}
 		originalMessage := celeryMessage.GetTaskMessage(ctx, time.Second)
+		// delivery info reflects actual routing done by the broker transport
+		originalMessage.DeliveryInfo = message.DeliveryInfo
 		if !reflect.DeepEqual(message, originalMessage) {
 			t.Errorf("test '%s': received message %v different from original message %v", tc.name, message, originalMessage)
 		}
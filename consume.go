@@ -0,0 +1,68 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"time"
+)
+
+// defaultPollInterval is delay between polls of broker which has no messages
+const defaultPollInterval = 100 * time.Millisecond
+
+// pollingBroker adapts CeleryBroker to StreamingBroker by polling GetTaskMessage
+type pollingBroker struct {
+	CeleryBroker
+	interval time.Duration
+}
+
+// NewPollingBroker adapts broker without streaming support to StreamingBroker
+// Broker is polled again after interval whenever it has no message ready.
+func NewPollingBroker(broker CeleryBroker, interval time.Duration) StreamingBroker {
+	if streaming, ok := broker.(StreamingBroker); ok {
+		return streaming
+	}
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &pollingBroker{CeleryBroker: broker, interval: interval}
+}
+
+// ConsumeTaskMessages streams task messages polled from broker
+// Message pending when ctx is done is sent back to broker.
+func (b *pollingBroker) ConsumeTaskMessages(ctx context.Context, timeout time.Duration) (<-chan *TaskMessage, error) {
+	taskMessages := make(chan *TaskMessage)
+	go func() {
+		defer close(taskMessages)
+		for ctx.Err() == nil {
+			taskMessage, err := b.GetTaskMessage(ctx, timeout)
+			if err != nil || taskMessage == nil {
+				sleepContext(ctx, b.interval)
+				continue
+			}
+			if ctx.Err() == nil {
+				select {
+				case taskMessages <- taskMessage:
+					continue
+				case <-ctx.Done():
+				}
+			}
+			if err := sendTaskMessage(context.WithoutCancel(ctx), b.CeleryBroker, timeout, taskMessage); err != nil {
+				taskLogger(ctx, nil, taskMessage).Error("failed to requeue task", "error", err)
+			}
+		}
+	}()
+	return taskMessages, nil
+}
+
+// sleepContext sleeps for given duration or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// emptyBroker never has messages and counts polls
+type emptyBroker struct {
+	polls atomic.Int64
+}
+
+func (b *emptyBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
+	return nil
+}
+
+func (b *emptyBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	b.polls.Add(1)
+	return nil, fmt.Errorf("queue is empty")
+}
+
+// TestPollingBroker tests adapting polling broker to stream of task messages
+func TestPollingBroker(t *testing.T) {
+	broker := &queueBroker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streaming := NewPollingBroker(broker, time.Millisecond)
+	if NewPollingBroker(streaming, time.Millisecond) != streaming {
+		t.Errorf("streaming broker should not be adapted again")
+	}
+	taskMessages, err := streaming.ConsumeTaskMessages(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to consume task messages: %v", err)
+	}
+
+	var expected []string
+	for i := 0; i < 3; i++ {
+		message := getTaskMessage(ctx, "add")
+		message.Args = []interface{}{i, i}
+		if err := sendTaskMessage(ctx, broker, TIMEOUT, message); err != nil {
+			t.Fatalf("failed to send task message: %v", err)
+		}
+		expected = append(expected, message.ID)
+	}
+	for i, id := range expected {
+		select {
+		case message := <-taskMessages:
+			if message.ID != id {
+				t.Errorf("test '%d': expected message %s, got %s", i, id, message.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("test '%d': message was not delivered", i)
+		}
+	}
+
+	// message received by broker but not consumed is returned to queue
+	late := getTaskMessage(ctx, "add")
+	broker.Lock()
+	broker.late = late
+	broker.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	for range taskMessages {
+		t.Errorf("no message should be delivered after context is cancelled")
+	}
+	if queued := broker.queued(); len(queued) != 1 || queued[0] != late.ID {
+		t.Errorf("expected message %s to be requeued, got %v", late.ID, queued)
+	}
+}
+
+// TestWorkerIdlePolling tests that idle worker does not busy-wait on empty broker
+func TestWorkerIdlePolling(t *testing.T) {
+	broker := &emptyBroker{}
+	backend := NewMemoryCeleryBackend()
+	worker := NewCeleryWorker(broker, backend, 10)
+	worker.StartWorker(context.Background(), TIMEOUT)
+	time.Sleep(250 * time.Millisecond)
+	worker.StopWorker()
+	if polls := broker.polls.Load(); polls > 5 {
+		t.Errorf("expected idle workers to poll broker at most 5 times, got %d", polls)
+	}
+}
@@ -5,15 +5,28 @@
 package gocelery
 
 import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
 	"reflect"
+	"strconv"
 )
 
 // GetRealValue returns real value of reflect.Value
 // Required for JSON Marshalling
 func GetRealValue(val *reflect.Value) interface{} {
-	if val == nil {
+	if val == nil || !val.IsValid() {
 		return nil
 	}
+	// values encoding themselves such as time.Time are kept intact
+	if val.CanInterface() && (val.Type().Implements(jsonMarshalerType) || val.Type().Implements(textMarshalerType)) {
+		if (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil() {
+			return nil
+		}
+		return val.Interface()
+	}
 	switch val.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return val.Int()
@@ -25,7 +38,266 @@ func GetRealValue(val *reflect.Value) interface{} {
 		return val.Uint()
 	case reflect.Float32, reflect.Float64:
 		return val.Float()
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return nil
+		}
+		elem := val.Elem()
+		if val.Kind() == reflect.Interface {
+			return GetRealValue(&elem)
+		}
+		return val.Interface()
 	default:
-		return nil
+		// slices, maps and structs are encoded by encoding/json,
+		// values which cannot be encoded are reported when result is stored
+		if !val.CanInterface() {
+			return nil
+		}
+		return val.Interface()
+	}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// ConvertArgument converts json decoded task argument into value of given type
+// Numbers are converted into any numeric type as long as they fit without loss,
+// lists into slices and arrays, objects into maps and structs (using json tags),
+// base64 strings into []byte and types implementing json.Unmarshaler
+// (such as time.Time) are decoded from their json representation.
+func ConvertArgument(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(typ), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use null as %s", typ)
+	}
+	val := reflect.ValueOf(arg)
+	if val.Type() == typ {
+		return val, nil
+	}
+	if typ.Kind() == reflect.Interface {
+		if !val.Type().Implements(typ) {
+			return reflect.Value{}, fmt.Errorf("%T does not implement %s", arg, typ)
+		}
+		converted := reflect.New(typ).Elem()
+		converted.Set(val)
+		return converted, nil
+	}
+	if reflect.PointerTo(typ).Implements(jsonUnmarshalerType) {
+		return convertJSON(arg, typ)
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		elem, err := ConvertArgument(arg, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	case reflect.Bool:
+		if val.Kind() == reflect.Bool {
+			return val.Convert(typ), nil
+		}
+	case reflect.String:
+		if val.Kind() == reflect.String {
+			return val.Convert(typ), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return convertInt(arg, typ)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return convertUint(arg, typ)
+	case reflect.Float32, reflect.Float64:
+		return convertFloat(arg, typ)
+	case reflect.Slice:
+		// json encodes []byte as base64 string
+		if typ.Elem().Kind() == reflect.Uint8 && val.Kind() == reflect.String {
+			data, err := base64.StdEncoding.DecodeString(val.String())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot decode base64 string as %s: %v", typ, err)
+			}
+			return reflect.ValueOf(data).Convert(typ), nil
+		}
+		if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+			converted := reflect.MakeSlice(typ, val.Len(), val.Len())
+			return converted, convertElements(val, converted)
+		}
+	case reflect.Array:
+		if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+			if val.Len() != typ.Len() {
+				return reflect.Value{}, fmt.Errorf("cannot use list of length %d as %s", val.Len(), typ)
+			}
+			converted := reflect.New(typ).Elem()
+			return converted, convertElements(val, converted)
+		}
+	case reflect.Map:
+		if val.Kind() == reflect.Map {
+			return convertMap(val, typ)
+		}
+	case reflect.Struct:
+		if val.Kind() == reflect.Map || val.Kind() == reflect.Struct {
+			return convertJSON(arg, typ)
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+}
+
+// convertElements converts elements of list into elements of slice or array
+func convertElements(val reflect.Value, converted reflect.Value) error {
+	for i := 0; i < val.Len(); i++ {
+		elem, err := ConvertArgument(val.Index(i).Interface(), converted.Type().Elem())
+		if err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+		converted.Index(i).Set(elem)
+	}
+	return nil
+}
+
+// convertMap converts map, json object keys are parsed if map has numeric keys
+func convertMap(val reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	converted := reflect.MakeMapWithSize(typ, val.Len())
+	iter := val.MapRange()
+	for iter.Next() {
+		key := iter.Key().Interface()
+		if s, ok := key.(string); ok && typ.Key().Kind() != reflect.String {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				key = f
+			}
+		}
+		convertedKey, err := ConvertArgument(key, typ.Key())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("key %v: %w", iter.Key(), err)
+		}
+		convertedValue, err := ConvertArgument(iter.Value().Interface(), typ.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("value of key %v: %w", iter.Key(), err)
+		}
+		converted.SetMapIndex(convertedKey, convertedValue)
+	}
+	return converted, nil
+}
+
+// convertJSON converts value using its json representation
+func convertJSON(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	argBytes, err := json.Marshal(arg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	converted := reflect.New(typ)
+	if err := json.Unmarshal(argBytes, converted.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("cannot convert %T to %s: %v", arg, typ, err)
+	}
+	return converted.Elem(), nil
+}
+
+func convertInt(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	var i int64
+	val := reflect.ValueOf(arg)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if val.Uint() > math.MaxInt64 {
+			return reflect.Value{}, fmt.Errorf("%v overflows %s", arg, typ)
+		}
+		i = int64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %s", arg, typ)
+		}
+		i = int64(f)
+	case reflect.String:
+		n, ok := arg.(json.Number)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+		}
+		var err error
+		if i, err = n.Int64(); err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %s", arg, typ)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+	}
+	converted := reflect.New(typ).Elem()
+	if converted.OverflowInt(i) {
+		return reflect.Value{}, fmt.Errorf("%v overflows %s", arg, typ)
+	}
+	converted.SetInt(i)
+	return converted, nil
+}
+
+func convertUint(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	var u uint64
+	val := reflect.ValueOf(arg)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.Int() < 0 {
+			return reflect.Value{}, fmt.Errorf("cannot use negative %v as %s", arg, typ)
+		}
+		u = uint64(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u = val.Uint()
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %s", arg, typ)
+		}
+		u = uint64(f)
+	case reflect.String:
+		n, ok := arg.(json.Number)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+		}
+		var err error
+		if u, err = strconv.ParseUint(n.String(), 10, 64); err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %s", arg, typ)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+	}
+	converted := reflect.New(typ).Elem()
+	if converted.OverflowUint(u) {
+		return reflect.Value{}, fmt.Errorf("%v overflows %s", arg, typ)
+	}
+	converted.SetUint(u)
+	return converted, nil
+}
+
+func convertFloat(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	var f float64
+	val := reflect.ValueOf(arg)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f = float64(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f = float64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		f = val.Float()
+	case reflect.String:
+		n, ok := arg.(json.Number)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+		}
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %s", arg, typ)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", arg, typ)
+	}
+	converted := reflect.New(typ).Elem()
+	if converted.OverflowFloat(f) {
+		return reflect.Value{}, fmt.Errorf("%v overflows %s", arg, typ)
 	}
+	converted.SetFloat(f)
+	return converted, nil
 }
@@ -0,0 +1,161 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type convertPoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// TestConvertArgument tests conversion of json decoded values into parameter types
+func TestConvertArgument(t *testing.T) {
+	seven := 7
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		arg      interface{}
+		typ      reflect.Type
+		expected interface{}
+		hasError bool
+	}{
+		{name: "float64 to int", arg: float64(3), typ: reflect.TypeOf(int(0)), expected: 3},
+		{name: "float64 to int64", arg: float64(1 << 40), typ: reflect.TypeOf(int64(0)), expected: int64(1 << 40)},
+		{name: "float64 to uint", arg: float64(3), typ: reflect.TypeOf(uint(0)), expected: uint(3)},
+		{name: "float64 to float32", arg: 1.5, typ: reflect.TypeOf(float32(0)), expected: float32(1.5)},
+		{name: "int to float64", arg: 2, typ: reflect.TypeOf(float64(0)), expected: float64(2)},
+		{name: "json number to int", arg: json.Number("42"), typ: reflect.TypeOf(int(0)), expected: 42},
+		{name: "fractional float64 to int", arg: 1.5, typ: reflect.TypeOf(int(0)), hasError: true},
+		{name: "negative float64 to uint", arg: float64(-1), typ: reflect.TypeOf(uint(0)), hasError: true},
+		{name: "overflowing float64 to int8", arg: float64(300), typ: reflect.TypeOf(int8(0)), hasError: true},
+		{name: "string to int", arg: "1", typ: reflect.TypeOf(int(0)), hasError: true},
+		{name: "null to int", arg: nil, typ: reflect.TypeOf(int(0)), hasError: true},
+		{name: "null to pointer", arg: nil, typ: reflect.TypeOf(&seven), expected: (*int)(nil)},
+		{name: "float64 to pointer", arg: float64(7), typ: reflect.TypeOf(&seven), expected: &seven},
+		{
+			name:     "list to slice",
+			arg:      []interface{}{float64(1), float64(2)},
+			typ:      reflect.TypeOf([]int{}),
+			expected: []int{1, 2},
+		},
+		{
+			name:     "list to array",
+			arg:      []interface{}{"a", "b"},
+			typ:      reflect.TypeOf([2]string{}),
+			expected: [2]string{"a", "b"},
+		},
+		{
+			name:     "list to array of different length",
+			arg:      []interface{}{"a"},
+			typ:      reflect.TypeOf([2]string{}),
+			hasError: true,
+		},
+		{
+			name:     "list with mismatching element",
+			arg:      []interface{}{float64(1), "b"},
+			typ:      reflect.TypeOf([]int{}),
+			hasError: true,
+		},
+		{
+			name:     "object to map",
+			arg:      map[string]interface{}{"a": float64(1)},
+			typ:      reflect.TypeOf(map[string]int{}),
+			expected: map[string]int{"a": 1},
+		},
+		{
+			name:     "object to map with integer keys",
+			arg:      map[string]interface{}{"1": "a"},
+			typ:      reflect.TypeOf(map[int]string{}),
+			expected: map[int]string{1: "a"},
+		},
+		{
+			name:     "object to struct",
+			arg:      map[string]interface{}{"x": float64(1), "y": float64(2)},
+			typ:      reflect.TypeOf(convertPoint{}),
+			expected: convertPoint{X: 1, Y: 2},
+		},
+		{
+			name:     "object to struct pointer",
+			arg:      map[string]interface{}{"x": float64(1)},
+			typ:      reflect.TypeOf(&convertPoint{}),
+			expected: &convertPoint{X: 1},
+		},
+		{
+			name:     "string to time",
+			arg:      "2020-01-02T03:04:05Z",
+			typ:      reflect.TypeOf(time.Time{}),
+			expected: timestamp,
+		},
+		{
+			name:     "base64 string to bytes",
+			arg:      "aGVsbG8=",
+			typ:      reflect.TypeOf([]byte{}),
+			expected: []byte("hello"),
+		},
+		{
+			name:     "list to interface",
+			arg:      []interface{}{float64(1)},
+			typ:      reflect.TypeOf((*interface{})(nil)).Elem(),
+			expected: []interface{}{float64(1)},
+		},
+	}
+	for _, tc := range testCases {
+		val, err := ConvertArgument(tc.arg, tc.typ)
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("test '%s': expected error but converted %v", tc.name, val)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test '%s': failed to convert %v: %v", tc.name, tc.arg, err)
+			continue
+		}
+		if val.Type() != tc.typ {
+			t.Errorf("test '%s': converted type %s is different from expected %s", tc.name, val.Type(), tc.typ)
+		}
+		if !reflect.DeepEqual(val.Interface(), tc.expected) {
+			t.Errorf("test '%s': converted value %v is different from expected %v", tc.name, val.Interface(), tc.expected)
+		}
+	}
+}
+
+// TestGetRealValue tests encoding of task function results
+func TestGetRealValue(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{name: "integer", value: 3, expected: `3`},
+		{name: "string slice", value: []string{"a", "b"}, expected: `["a","b"]`},
+		{name: "map", value: map[string]int{"a": 1}, expected: `{"a":1}`},
+		{name: "struct", value: convertPoint{X: 1, Y: 2}, expected: `{"x":1,"y":2}`},
+		{name: "struct pointer", value: &convertPoint{X: 1}, expected: `{"x":1,"y":0}`},
+		{name: "nil pointer", value: (*convertPoint)(nil), expected: `null`},
+		{name: "time", value: timestamp, expected: `"2020-01-02T03:04:05Z"`},
+		{name: "bytes", value: []byte("hello"), expected: `"aGVsbG8="`},
+		{name: "json marshaler", value: json.RawMessage(`{"raw":true}`), expected: `{"raw":true}`},
+		{name: "duration", value: time.Second, expected: `1000000000`},
+	}
+	for _, tc := range testCases {
+		val := reflect.ValueOf(tc.value)
+		encoded, err := json.Marshal(GetRealValue(&val))
+		if err != nil {
+			t.Errorf("test '%s': failed to encode value %v: %v", tc.name, tc.value, err)
+			continue
+		}
+		if string(encoded) != tc.expected {
+			t.Errorf("test '%s': encoded value %s is different from expected %s", tc.name, encoded, tc.expected)
+		}
+	}
+}
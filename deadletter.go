@@ -0,0 +1,273 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PerformLine/go-stockutil/stringutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// DeadLetterReason describes why message was dead-lettered
+type DeadLetterReason string
+
+// reasons of dead-lettering messages
+const (
+	DeadLetterDecodeError        DeadLetterReason = "decode_error"
+	DeadLetterUnregisteredTask   DeadLetterReason = "unregistered_task"
+	DeadLetterMaxRetriesExceeded DeadLetterReason = "max_retries_exceeded"
+)
+
+// ErrDeadLetterNotFound is returned for unknown dead letter ID
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is message which could not be processed
+// Body holds original celery message as published to broker.
+type DeadLetter struct {
+	ID             string           `json:"id"`
+	Reason         DeadLetterReason `json:"reason"`
+	Error          string           `json:"error"`
+	Queue          string           `json:"queue"`
+	TaskID         string           `json:"task_id,omitempty"`
+	Task           string           `json:"task,omitempty"`
+	Body           []byte           `json:"body"`
+	DeadLetteredAt time.Time        `json:"dead_lettered_at"`
+}
+
+// DeadLetterQueue stores dead-lettered messages
+// List returns messages in order they were dead-lettered.
+type DeadLetterQueue interface {
+	Put(ctx context.Context, deadLetter *DeadLetter) error
+	List(ctx context.Context) ([]*DeadLetter, error)
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// deadLetterSetter is implemented by brokers dead-lettering undecodable messages
+type deadLetterSetter interface {
+	SetDeadLetterQueue(dlq DeadLetterQueue)
+}
+
+// newDeadLetter creates dead letter of original message body
+func newDeadLetter(reason DeadLetterReason, err error, queue string, body []byte) *DeadLetter {
+	deadLetter := &DeadLetter{
+		ID:             stringutil.UUID().String(),
+		Reason:         reason,
+		Queue:          queue,
+		Body:           body,
+		DeadLetteredAt: time.Now().UTC(),
+	}
+	if err != nil {
+		deadLetter.Error = err.Error()
+	}
+	return deadLetter
+}
+
+// newTaskDeadLetter creates dead letter of task message
+func newTaskDeadLetter(reason DeadLetterReason, err error, message *TaskMessage) (*DeadLetter, error) {
+	celeryMessage, encodeErr := encodeCeleryMessage(message)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	defer releaseCeleryMessage(celeryMessage)
+	body, encodeErr := json.Marshal(celeryMessage)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	deadLetter := newDeadLetter(reason, err, messageQueue(message), body)
+	deadLetter.TaskID, deadLetter.Task = message.ID, message.Task
+	return deadLetter, nil
+}
+
+// putDeadLetter stores dead letter logging failure
+// It does nothing if dead letter queue is not configured.
+func putDeadLetter(ctx context.Context, dlq DeadLetterQueue, logger Logger, deadLetter *DeadLetter) {
+	if dlq == nil {
+		return
+	}
+	if err := dlq.Put(ctx, deadLetter); err != nil {
+		loggerOrDefault(logger).Error("failed to dead-letter message", "reason", deadLetter.Reason, "error", err)
+	}
+}
+
+// ReplayDeadLetter sends dead-lettered message back to its original queue
+// and removes it from dead letter queue. Retries of replayed task start from zero.
+func ReplayDeadLetter(ctx context.Context, dlq DeadLetterQueue, broker CeleryBroker, timeout time.Duration, id string) error {
+	deadLetter, err := dlq.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	var celeryMessage CeleryMessage
+	if err := json.Unmarshal(deadLetter.Body, &celeryMessage); err != nil {
+		return fmt.Errorf("failed to decode dead letter %s: %w", id, err)
+	}
+	if taskMessage, err := celeryMessage.decodeTaskMessage(); err == nil && taskMessage.Retries > 0 {
+		taskMessage.Retries = 0
+		if celeryMessage.Body, err = taskMessage.Encode(); err != nil {
+			return err
+		}
+	}
+	if err := broker.SendCeleryMessage(ctx, timeout, &celeryMessage); err != nil {
+		return fmt.Errorf("failed to replay dead letter %s: %w", id, err)
+	}
+	return dlq.Delete(ctx, id)
+}
+
+// MemoryDeadLetterQueue keeps dead letters in memory
+type MemoryDeadLetterQueue struct {
+	lock        sync.Mutex
+	deadLetters map[string]*DeadLetter
+	order       []string
+}
+
+// NewMemoryDeadLetterQueue creates new MemoryDeadLetterQueue
+func NewMemoryDeadLetterQueue() *MemoryDeadLetterQueue {
+	return &MemoryDeadLetterQueue{deadLetters: map[string]*DeadLetter{}}
+}
+
+// Put stores dead letter
+func (q *MemoryDeadLetterQueue) Put(ctx context.Context, deadLetter *DeadLetter) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.deadLetters[deadLetter.ID]; !ok {
+		q.order = append(q.order, deadLetter.ID)
+	}
+	stored := *deadLetter
+	q.deadLetters[deadLetter.ID] = &stored
+	return nil
+}
+
+// List returns all dead letters
+func (q *MemoryDeadLetterQueue) List(ctx context.Context) ([]*DeadLetter, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	deadLetters := make([]*DeadLetter, 0, len(q.order))
+	for _, id := range q.order {
+		deadLetter := *q.deadLetters[id]
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// Get returns dead letter with given ID
+func (q *MemoryDeadLetterQueue) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	deadLetter, ok := q.deadLetters[id]
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	stored := *deadLetter
+	return &stored, nil
+}
+
+// Delete removes dead letter with given ID
+func (q *MemoryDeadLetterQueue) Delete(ctx context.Context, id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.deadLetters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(q.deadLetters, id)
+	for i, stored := range q.order {
+		if stored == id {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// RedisDeadLetterQueue keeps dead letters in redis
+// IDs are kept in list under key in order of dead-lettering,
+// dead letters are stored in hash under key suffixed with ".messages"
+// and hash tagged to share cluster slot with the list.
+type RedisDeadLetterQueue struct {
+	client redis.UniversalClient
+	key    string
+}
+
+// NewRedisDeadLetterQueue creates new RedisDeadLetterQueue stored under given key
+func NewRedisDeadLetterQueue(client redis.UniversalClient, key string) *RedisDeadLetterQueue {
+	return &RedisDeadLetterQueue{client: client, key: key}
+}
+
+func (q *RedisDeadLetterQueue) messagesKey() string {
+	return redisSlotKey(q.key, ".messages")
+}
+
+// Put stores dead letter
+func (q *RedisDeadLetterQueue) Put(ctx context.Context, deadLetter *DeadLetter) error {
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, q.messagesKey(), deadLetter.ID, data)
+	pipe.RPush(ctx, q.key, deadLetter.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// List returns all dead letters
+func (q *RedisDeadLetterQueue) List(ctx context.Context) ([]*DeadLetter, error) {
+	ids, err := q.client.LRange(ctx, q.key, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	values, err := q.client.HMGet(ctx, q.messagesKey(), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := make([]*DeadLetter, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var deadLetter DeadLetter
+		if err := json.Unmarshal([]byte(data), &deadLetter); err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// Get returns dead letter with given ID
+func (q *RedisDeadLetterQueue) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	data, err := q.client.HGet(ctx, q.messagesKey(), id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deadLetter DeadLetter
+	if err := json.Unmarshal(data, &deadLetter); err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// Delete removes dead letter with given ID
+func (q *RedisDeadLetterQueue) Delete(ctx context.Context, id string) error {
+	pipe := q.client.TxPipeline()
+	deleted := pipe.HDel(ctx, q.messagesKey(), id)
+	pipe.LRem(ctx, q.key, 1, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if deleted.Val() == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestDeadLetterUnregisteredTask tests dead-lettering and replaying message of unregistered task
+func TestDeadLetterUnregisteredTask(t *testing.T) {
+	cli := newLoopbackClient()
+	dlq := NewMemoryDeadLetterQueue()
+	cli.SetDeadLetterQueue(dlq)
+	cli.SetUnregisteredTaskPolicy(UnregisteredTaskPolicy{Action: UnregisteredTaskReject})
+	cli.SetLogger(NopLogger())
+
+	ctx := context.Background()
+	asyncResult, err := cli.DelayKwargs(ctx, TIMEOUT, "add", map[string]interface{}{"a": 3, "b": 4}, "math")
+	if err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	deadLetters, _ := dlq.List(ctx)
+	if len(deadLetters) != 1 {
+		t.Fatalf("expected single dead letter, got %d", len(deadLetters))
+	}
+	deadLetter := deadLetters[0]
+	if deadLetter.Reason != DeadLetterUnregisteredTask || deadLetter.TaskID != asyncResult.TaskID() ||
+		deadLetter.Task != "add" || deadLetter.Queue != "math" || deadLetter.Error == "" {
+		t.Errorf("unexpected dead letter %+v", deadLetter)
+	}
+
+	cli.Register("add", &addIntTask{})
+	if err := cli.ReplayDeadLetter(ctx, TIMEOUT, deadLetter.ID); err != nil {
+		t.Fatalf("failed to replay dead letter: %v", err)
+	}
+	res, err := asyncResult.Get(ctx, TIMEOUT)
+	if err != nil || fmt.Sprint(res) != "7" {
+		t.Errorf("expected replayed task to return 7, got %v (%v)", res, err)
+	}
+	if _, err := dlq.Get(ctx, deadLetter.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("replayed dead letter should be removed, got %v", err)
+	}
+}
+
+// TestDeadLetterMaxRetries tests dead-lettering task which exhausted its retries
+func TestDeadLetterMaxRetries(t *testing.T) {
+	cli := newLoopbackClient()
+	dlq := NewMemoryDeadLetterQueue()
+	cli.SetDeadLetterQueue(dlq)
+	cli.Register("flaky", func() error {
+		return Retry(errors.New("unavailable"), 0, 2)
+	})
+
+	ctx := context.Background()
+	if _, err := cli.Delay(ctx, TIMEOUT, "flaky"); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	deadLetters, _ := dlq.List(ctx)
+	if len(deadLetters) != 1 || deadLetters[0].Reason != DeadLetterMaxRetriesExceeded {
+		t.Fatalf("expected single dead letter of exhausted retries, got %+v", deadLetters)
+	}
+
+	// replayed task starts retrying from zero
+	capture := &captureBroker{}
+	if err := ReplayDeadLetter(ctx, dlq, capture, TIMEOUT, deadLetters[0].ID); err != nil {
+		t.Fatalf("failed to replay dead letter: %v", err)
+	}
+	if len(capture.messages) != 1 || capture.messages[0].Retries != 0 || capture.messages[0].Task != "flaky" {
+		t.Errorf("unexpected replayed message %+v", capture.messages)
+	}
+	if err := ReplayDeadLetter(ctx, dlq, capture, TIMEOUT, deadLetters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("expected replayed dead letter to be removed, got %v", err)
+	}
+}
+
+// TestMemoryDeadLetterQueue tests order and removal of dead letters
+func TestMemoryDeadLetterQueue(t *testing.T) {
+	ctx := context.Background()
+	dlq := NewMemoryDeadLetterQueue()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		deadLetter := newDeadLetter(DeadLetterDecodeError, fmt.Errorf("error %d", i), "celery", []byte("{}"))
+		if err := dlq.Put(ctx, deadLetter); err != nil {
+			t.Fatalf("failed to put dead letter: %v", err)
+		}
+		ids = append(ids, deadLetter.ID)
+	}
+	if err := dlq.Delete(ctx, ids[1]); err != nil {
+		t.Errorf("failed to delete dead letter: %v", err)
+	}
+	if err := dlq.Delete(ctx, ids[1]); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("expected ErrDeadLetterNotFound, got %v", err)
+	}
+	deadLetters, _ := dlq.List(ctx)
+	if len(deadLetters) != 2 || deadLetters[0].ID != ids[0] || deadLetters[1].ID != ids[2] || deadLetters[1].Error != "error 2" {
+		t.Errorf("unexpected dead letters %+v", deadLetters)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"time"
+)
+
+// exampleAddArgs are arguments of integer addition task
+// accepted both as positional (a, b) and named arguments
+type exampleAddArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func Example_workerWithTypedTask() {
+
+	// initialize celery client
+	cli, _ := NewCeleryClient(
+		NewRedisCeleryBroker("redis://"),
+		NewRedisCeleryBackend("redis://"),
+		5, // number of workers
+	)
+
+	// register typed task
+	RegisterTyped(cli, "add", func(ctx context.Context, args exampleAddArgs) (int, error) {
+		return args.A + args.B, nil
+	})
+
+	// start workers (non-blocking call)
+	cli.StartWorker(context.Background(), TIMEOUT)
+
+	// wait for client request
+	time.Sleep(10 * time.Second)
+
+	// stop workers gracefully (blocking call)
+	cli.StopWorker()
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+//go:build !unix
+
+package gocelery
+
+import (
+	"os"
+)
+
+// lockFile does nothing where advisory file locks are not supported,
+// files are still published by atomic rename
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile does nothing where advisory file locks are not supported
+func unlockFile(f *os.File) error {
+	return nil
+}
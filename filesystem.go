@@ -0,0 +1,60 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/PerformLine/go-stockutil/stringutil"
+)
+
+// writeFileAtomic writes file under exclusive lock to temporary name
+// and renames it to path, so that readers never see partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+stringutil.UUID().String()+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	err = writeLocked(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+func writeLocked(f *os.File, data []byte) error {
+	if err := lockFile(f, true); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readFileLocked reads file under shared lock
+// waiting for writer holding exclusive lock to finish
+func readFileLocked(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := lockFile(f, false); err != nil {
+		return nil, err
+	}
+	defer unlockFile(f)
+	return io.ReadAll(f)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FilesystemCeleryBackend is celery backend storing result of each task in its own file
+// Files are named "celery-task-meta-<task id>" as by celery filesystem backend.
+// Results are written by atomic rename under file lock, so that multiple
+// worker processes on the same host may share the folder.
+type FilesystemCeleryBackend struct {
+	folder string
+}
+
+// NewFilesystemCeleryBackend creates new FilesystemCeleryBackend storing results in given folder
+// Folder is created if it does not exist.
+func NewFilesystemCeleryBackend(folder string) (*FilesystemCeleryBackend, error) {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+	return &FilesystemCeleryBackend{folder: folder}, nil
+}
+
+func (b *FilesystemCeleryBackend) resultPath(taskID string) string {
+	return filepath.Join(b.folder, fmt.Sprintf("celery-task-meta-%s", taskID))
+}
+
+// GetResult reads result of given task
+func (b *FilesystemCeleryBackend) GetResult(ctx context.Context, taskID string) (*ResultMessage, error) {
+	data, err := readFileLocked(b.resultPath(taskID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("result not available")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resultMessage ResultMessage
+	if err := json.Unmarshal(data, &resultMessage); err != nil {
+		return nil, err
+	}
+	return &resultMessage, nil
+}
+
+// SetResult writes result of given task
+func (b *FilesystemCeleryBackend) SetResult(ctx context.Context, taskID string, result *ResultMessage) error {
+	resBytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.resultPath(taskID), resBytes)
+}
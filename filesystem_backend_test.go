@@ -0,0 +1,41 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFilesystemBackendResult tests storing results in celery filesystem backend layout
+func TestFilesystemBackendResult(t *testing.T) {
+	ctx := context.Background()
+	folder := t.TempDir()
+	backend, err := NewFilesystemCeleryBackend(folder)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	if _, err := backend.GetResult(ctx, "missing"); err == nil {
+		t.Errorf("result of unknown task should not be available")
+	}
+	if err := backend.SetResult(ctx, "task-id", &ResultMessage{ID: "task-id", Status: "SUCCESS", Result: 3.0}); err != nil {
+		t.Fatalf("failed to set result: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "celery-task-meta-task-id")); err != nil {
+		t.Errorf("result file not found: %v", err)
+	}
+	result, err := backend.GetResult(ctx, "task-id")
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if result.Status != "SUCCESS" || result.Result != 3.0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if entries, _ := os.ReadDir(folder); len(entries) != 1 {
+		t.Errorf("temporary files should not remain, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PerformLine/go-stockutil/stringutil"
+)
+
+// FilesystemCeleryBroker is celery broker compatible with kombu filesystem transport
+// Messages are published as files named "<millis>_<uuid>.<queue>.msg" to data folder out
+// and consumed from data folder in. Consumer claims message by atomic rename
+// to processing folder, therefore folders must reside on the same filesystem.
+// Multiple worker processes on the same host may share the folders.
+// Message claimed by consumer which crashed before reading it is moved back
+// to data folder in once it was claimed longer than staleClaimAge ago.
+type FilesystemCeleryBroker struct {
+	dataFolderIn     string
+	dataFolderOut    string
+	processingFolder string
+	storeProcessed   bool
+	queues           []string
+	settingsLock     sync.RWMutex
+	logger           Logger
+	deadLetters      DeadLetterQueue
+
+	sendLock   sync.Mutex
+	lastMillis int64
+}
+
+// NewFilesystemCeleryBroker creates new FilesystemCeleryBroker consuming given queues
+// from dataFolderIn and publishing to dataFolderOut. Queue "celery" is consumed
+// if no queues are given. Folders are created if they do not exist and stale claimed
+// messages are moved back to data folder in.
+func NewFilesystemCeleryBroker(dataFolderIn, dataFolderOut string, queues ...string) (*FilesystemCeleryBroker, error) {
+	if len(queues) == 0 {
+		queues = []string{"celery"}
+	}
+	broker := &FilesystemCeleryBroker{
+		dataFolderIn:     dataFolderIn,
+		dataFolderOut:    dataFolderOut,
+		processingFolder: filepath.Join(dataFolderIn, ".processing"),
+		queues:           queues,
+	}
+	for _, folder := range []string{dataFolderIn, dataFolderOut, broker.processingFolder} {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := broker.recoverClaimed(staleClaimAge); err != nil {
+		return nil, err
+	}
+	return broker, nil
+}
+
+// staleClaimAge is time after which claimed message is considered abandoned
+// Consumer reads message right after claiming it, so that only crashed consumer
+// leaves claimed message behind.
+const staleClaimAge = time.Minute
+
+// recoverClaimed moves messages claimed longer than age ago back to data folder in
+func (b *FilesystemCeleryBroker) recoverClaimed(age time.Duration) error {
+	entries, err := os.ReadDir(b.processingFolder)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".msg") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < age {
+			continue
+		}
+		claimed := filepath.Join(b.processingFolder, entry.Name())
+		if err := os.Rename(claimed, filepath.Join(b.dataFolderIn, entry.Name())); err != nil {
+			b.getLogger().Error("failed to recover claimed message", "file", entry.Name(), "error", err)
+		}
+	}
+	return nil
+}
+
+// SetProcessedFolder keeps consumed messages in given folder instead of removing them,
+// same as store_processed option of kombu filesystem transport. Messages claimed
+// there by crashed consumer are not recovered, as they cannot be told from processed ones.
+func (b *FilesystemCeleryBroker) SetProcessedFolder(folder string) error {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+	b.processingFolder = folder
+	b.storeProcessed = true
+	return nil
+}
+
+// SendCeleryMessage writes CeleryMessage to data folder out
+// Message is addressed to queue of its delivery info.
+func (b *FilesystemCeleryBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	queue := message.Properties.DeliveryInfo.queueName()
+	filename := fmt.Sprintf("%d_%s.%s.msg", b.nextMillis(), stringutil.UUID().String(), queue)
+	return writeFileAtomic(filepath.Join(b.dataFolderOut, filename), data)
+}
+
+// nextMillis returns timestamp of sent message file
+// Timestamps increase strictly, so messages sent by the broker are consumed in order.
+func (b *FilesystemCeleryBroker) nextMillis() int64 {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+	millis := time.Now().UnixMilli()
+	if millis <= b.lastMillis {
+		millis = b.lastMillis + 1
+	}
+	b.lastMillis = millis
+	return millis
+}
+
+// GetTaskMessage claims oldest message of consumed queues in data folder in
+// Message which cannot be read is moved back to data folder in.
+func (b *FilesystemCeleryBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	entries, err := os.ReadDir(b.dataFolderIn)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		queue, ok := b.messageQueue(entry)
+		if !ok {
+			continue
+		}
+		path := filepath.Join(b.dataFolderIn, entry.Name())
+		claimed := filepath.Join(b.processingFolder, entry.Name())
+		if err := os.Rename(path, claimed); err != nil {
+			// claimed by another consumer in the meantime
+			continue
+		}
+		// modification time marks claim, so that message is not recovered while read
+		now := time.Now()
+		os.Chtimes(claimed, now, now)
+		data, err := readFileLocked(claimed)
+		if err != nil {
+			logger := b.getLogger().With("queue", queue)
+			logger.Error("failed to read message", "file", entry.Name(), "error", err)
+			if err := os.Rename(claimed, path); err != nil {
+				logger.Error("failed to return message", "file", entry.Name(), "error", err)
+			}
+			continue
+		}
+		if !b.storeProcessed {
+			os.Remove(claimed)
+		}
+		var celeryMessage CeleryMessage
+		if err := json.Unmarshal(data, &celeryMessage); err != nil {
+			b.deadLetter(ctx, queue, data, err)
+			continue
+		}
+		celeryMessage.Properties.DeliveryInfo.Queue = queue
+		taskMessage, err := celeryMessage.decodeTaskMessage()
+		if err != nil {
+			b.deadLetter(ctx, queue, data, err)
+			continue
+		}
+		return taskMessage, nil
+	}
+	return nil, fmt.Errorf("no message in queues %s", strings.Join(b.queues, ", "))
+}
+
+// messageQueue returns consumed queue of message file in data folder in
+func (b *FilesystemCeleryBroker) messageQueue(entry os.DirEntry) (string, bool) {
+	if entry.IsDir() {
+		return "", false
+	}
+	for _, queue := range b.queues {
+		if strings.HasSuffix(entry.Name(), "."+queue+".msg") {
+			return queue, true
+		}
+	}
+	return "", false
+}
+
+// QueueDepth returns number of messages waiting in given queue
+func (b *FilesystemCeleryBroker) QueueDepth(ctx context.Context, queue string) (int64, error) {
+	entries, err := os.ReadDir(b.dataFolderIn)
+	if err != nil {
+		return 0, err
+	}
+	var depth int64
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), "."+queue+".msg") {
+			depth++
+		}
+	}
+	return depth, nil
+}
+
+// SetLogger sets logger used to report undecodable messages
+func (b *FilesystemCeleryBroker) SetLogger(logger Logger) {
+	b.settingsLock.Lock()
+	b.logger = logger
+	b.settingsLock.Unlock()
+}
+
+// getLogger returns logger of the broker
+func (b *FilesystemCeleryBroker) getLogger() Logger {
+	b.settingsLock.RLock()
+	defer b.settingsLock.RUnlock()
+	return loggerOrDefault(b.logger)
+}
+
+// SetDeadLetterQueue sets queue receiving messages which cannot be decoded
+func (b *FilesystemCeleryBroker) SetDeadLetterQueue(dlq DeadLetterQueue) {
+	b.settingsLock.Lock()
+	b.deadLetters = dlq
+	b.settingsLock.Unlock()
+}
+
+// getDeadLetterQueue returns queue receiving messages which cannot be decoded
+func (b *FilesystemCeleryBroker) getDeadLetterQueue() DeadLetterQueue {
+	b.settingsLock.RLock()
+	defer b.settingsLock.RUnlock()
+	return b.deadLetters
+}
+
+// deadLetter reports undecodable message of given queue and stores it in dead letter queue
+func (b *FilesystemCeleryBroker) deadLetter(ctx context.Context, queue string, body []byte, err error) {
+	logger := b.getLogger().With("queue", queue)
+	logger.Error("failed to decode message", "error", err)
+	putDeadLetter(ctx, b.getDeadLetterQueue(), logger, newDeadLetter(DeadLetterDecodeError, err, queue, body))
+}
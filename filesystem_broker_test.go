@@ -0,0 +1,274 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFilesystemBrokerQueues tests that filesystem broker consumes messages of its queue in order
+func TestFilesystemBrokerQueues(t *testing.T) {
+	ctx := context.Background()
+	folder := t.TempDir()
+	broker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	sendMemoryTask(t, broker, "first", nil, nil)
+	sendMemoryTask(t, broker, "other", &CeleryDeliveryInfo{RoutingKey: "other"}, nil)
+	sendMemoryTask(t, broker, "second", nil, nil)
+
+	if depth, _ := broker.QueueDepth(ctx, "celery"); depth != 2 {
+		t.Errorf("expected 2 messages in celery queue, got %d", depth)
+	}
+	for _, expected := range []string{"first", "second"} {
+		message, err := broker.GetTaskMessage(ctx, TIMEOUT)
+		if err != nil {
+			t.Fatalf("failed to get message %s: %v", expected, err)
+		}
+		if message.Task != expected {
+			t.Errorf("expected message %s, got %s", expected, message.Task)
+		}
+	}
+	if _, err := broker.GetTaskMessage(ctx, TIMEOUT); err == nil {
+		t.Errorf("message of queue which is not consumed should not be delivered")
+	}
+	if depth, _ := broker.QueueDepth(ctx, "other"); depth != 1 {
+		t.Errorf("expected 1 message in other queue, got %d", depth)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(folder, ".processing")); len(entries) != 0 {
+		t.Errorf("consumed messages should be removed, found %d", len(entries))
+	}
+}
+
+// TestFilesystemBrokerKombuMessage tests decoding of message written by kombu filesystem transport
+func TestFilesystemBrokerKombuMessage(t *testing.T) {
+	folder := t.TempDir()
+	broker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	body := base64.StdEncoding.EncodeToString([]byte(`{"id": "kombu-id", "task": "add", "args": [1, 2], "kwargs": {}, "retries": 0, "eta": null}`))
+	data := `{"body": "` + body + `", "content-encoding": "utf-8", "content-type": "application/json", "headers": {}, ` +
+		`"properties": {"body_encoding": "base64", "delivery_info": {"exchange": "", "routing_key": "celery"}, ` +
+		`"delivery_mode": 2, "priority": 0, "delivery_tag": "8d1f3a2e"}}`
+	if err := os.WriteFile(filepath.Join(folder, "1700000000000_8d1f3a2e.celery.msg"), []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	message, err := broker.GetTaskMessage(context.Background(), TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get message: %v", err)
+	}
+	if message.ID != "kombu-id" || message.Task != "add" || len(message.Args) != 2 {
+		t.Errorf("unexpected message %+v", message)
+	}
+}
+
+// TestFilesystemBrokerProcessed tests that consumed messages are kept in processed folder
+func TestFilesystemBrokerProcessed(t *testing.T) {
+	folder := t.TempDir()
+	processed := filepath.Join(t.TempDir(), "processed")
+	broker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	if err := broker.SetProcessedFolder(processed); err != nil {
+		t.Fatalf("failed to set processed folder: %v", err)
+	}
+	sendMemoryTask(t, broker, "kept", nil, nil)
+	if _, err := broker.GetTaskMessage(context.Background(), TIMEOUT); err != nil {
+		t.Fatalf("failed to get message: %v", err)
+	}
+	if entries, _ := os.ReadDir(processed); len(entries) != 1 {
+		t.Errorf("expected 1 processed message, found %d", len(entries))
+	}
+}
+
+// TestFilesystemBrokerConcurrent tests that each message is claimed by exactly one of concurrent consumers
+func TestFilesystemBrokerConcurrent(t *testing.T) {
+	folder := t.TempDir()
+	producer, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	const count = 50
+	for i := 0; i < count; i++ {
+		sendMemoryTask(t, producer, "task", nil, nil)
+	}
+
+	var lock sync.Mutex
+	var ids []string
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		consumer, err := NewFilesystemCeleryBroker(folder, folder)
+		if err != nil {
+			t.Fatalf("failed to create broker: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				message, err := consumer.GetTaskMessage(context.Background(), TIMEOUT)
+				if err != nil {
+					return
+				}
+				lock.Lock()
+				ids = append(ids, message.ID)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) != count {
+		t.Fatalf("expected %d messages, got %d", count, len(ids))
+	}
+	sort.Strings(ids)
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			t.Errorf("message %s was delivered more than once", ids[i])
+		}
+	}
+}
+
+// TestFilesystemBrokerNamedQueues tests consuming messages of configured queues
+func TestFilesystemBrokerNamedQueues(t *testing.T) {
+	ctx := context.Background()
+	folder := t.TempDir()
+	broker, err := NewFilesystemCeleryBroker(folder, folder, "video", "audio")
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	sendMemoryTask(t, broker, "default", nil, nil)
+	sendMemoryTask(t, broker, "encode video", &CeleryDeliveryInfo{RoutingKey: "video"}, nil)
+	sendMemoryTask(t, broker, "encode audio", &CeleryDeliveryInfo{RoutingKey: "audio"}, nil)
+
+	for _, tc := range []struct{ task, queue string }{{"encode video", "video"}, {"encode audio", "audio"}} {
+		message, err := broker.GetTaskMessage(ctx, TIMEOUT)
+		if err != nil {
+			t.Fatalf("failed to get message %s: %v", tc.task, err)
+		}
+		if message.Task != tc.task || message.DeliveryInfo.Queue != tc.queue {
+			t.Errorf("expected message %s of queue %s, got %s of queue %s", tc.task, tc.queue, message.Task, message.DeliveryInfo.Queue)
+		}
+	}
+	if message, err := broker.GetTaskMessage(ctx, TIMEOUT); err == nil {
+		t.Errorf("message of queue which is not consumed should not be delivered, got %s", message.Task)
+	}
+}
+
+// TestFilesystemBrokerUnreadable tests that message which cannot be read is returned to data folder in
+func TestFilesystemBrokerUnreadable(t *testing.T) {
+	folder := t.TempDir()
+	broker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	broker.SetLogger(NopLogger())
+	unreadable := "1_unreadable.celery.msg"
+	if err := os.Symlink(filepath.Join(folder, "missing"), filepath.Join(folder, unreadable)); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	sendMemoryTask(t, broker, "readable", nil, nil)
+
+	message, err := broker.GetTaskMessage(context.Background(), TIMEOUT)
+	if err != nil || message.Task != "readable" {
+		t.Fatalf("expected readable message, got %v (%v)", message, err)
+	}
+	if _, err := os.Lstat(filepath.Join(folder, unreadable)); err != nil {
+		t.Errorf("unreadable message should be returned to data folder: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(folder, ".processing")); len(entries) != 0 {
+		t.Errorf("unreadable message should not be left in processing folder, found %d", len(entries))
+	}
+}
+
+// TestFilesystemBrokerRecoverClaimed tests that messages left claimed by crashed consumer are consumed again
+func TestFilesystemBrokerRecoverClaimed(t *testing.T) {
+	folder := t.TempDir()
+	broker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	sendMemoryTask(t, broker, "stale", nil, nil)
+	sendMemoryTask(t, broker, "recent", nil, nil)
+
+	// claim messages without reading them like consumer crashing right after claim
+	entries, _ := os.ReadDir(folder)
+	var claimed []string
+	for _, entry := range entries {
+		if _, ok := broker.messageQueue(entry); !ok {
+			continue
+		}
+		path := filepath.Join(folder, ".processing", entry.Name())
+		if err := os.Rename(filepath.Join(folder, entry.Name()), path); err != nil {
+			t.Fatalf("failed to claim message: %v", err)
+		}
+		claimed = append(claimed, path)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("expected 2 claimed messages, got %d", len(claimed))
+	}
+	staleTime := time.Now().Add(-2 * staleClaimAge)
+	if err := os.Chtimes(claimed[0], staleTime, staleTime); err != nil {
+		t.Fatalf("failed to age claimed message: %v", err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(claimed[1], now, now); err != nil {
+		t.Fatalf("failed to touch claimed message: %v", err)
+	}
+
+	broker, err = NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	message, err := broker.GetTaskMessage(context.Background(), TIMEOUT)
+	if err != nil || message.Task != "stale" {
+		t.Fatalf("expected stale claimed message to be consumed again, got %v (%v)", message, err)
+	}
+	if message, err := broker.GetTaskMessage(context.Background(), TIMEOUT); err == nil {
+		t.Errorf("recently claimed message should not be recovered, got %s", message.Task)
+	}
+	if _, err := os.Stat(claimed[1]); err != nil {
+		t.Errorf("recently claimed message should stay in processing folder: %v", err)
+	}
+}
+
+// TestFilesystemBrokerSettings tests changing settings of broker while it consumes messages
+func TestFilesystemBrokerSettings(t *testing.T) {
+	folder := t.TempDir()
+	broker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	broker.SetLogger(NopLogger())
+	for i := 0; i < 20; i++ {
+		if err := os.WriteFile(filepath.Join(folder, fmt.Sprintf("%d_invalid.celery.msg", i)), []byte("{"), 0644); err != nil {
+			t.Fatalf("failed to write message: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			broker.GetTaskMessage(context.Background(), TIMEOUT)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		broker.SetLogger(NopLogger())
+		broker.SetDeadLetterQueue(NewMemoryDeadLetterQueue())
+	}
+	wg.Wait()
+}
@@ -6,7 +6,10 @@ package gocelery
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -15,12 +18,37 @@ type CeleryClient struct {
 	broker  CeleryBroker
 	backend CeleryBackend
 	worker  *CeleryWorker
+
+	middlewareLock      sync.RWMutex
+	publishMiddlewares  []PublishMiddleware
+	beforePublishSignal []TaskSignalHandler
+	propagator          Propagator
+	tracer              Tracer
+	metrics             *Metrics
+	routers             []Router
 }
 
 // CeleryBroker is interface for celery broker database
 type CeleryBroker interface {
 	SendCeleryMessage(context.Context, time.Duration, *CeleryMessage) error
-	GetTaskMessage(context.Context, time.Duration) (*TaskMessage, error) // must be non-blocking
+	GetTaskMessage(context.Context, time.Duration) (*TaskMessage, error) // must not block longer than timeout
+}
+
+// StreamingBroker is celery broker delivering task messages on channel
+// Returned channel is closed once ctx is done and broker stops consuming.
+// Message which cannot be delivered before ctx is done must be returned to queue.
+// Brokers implementing only CeleryBroker are adapted by NewPollingBroker.
+type StreamingBroker interface {
+	CeleryBroker
+	ConsumeTaskMessages(ctx context.Context, timeout time.Duration) (<-chan *TaskMessage, error)
+}
+
+// AcknowledgingBroker is celery broker keeping delivered messages until they are acknowledged
+// Worker acknowledges message once it is processed, message which worker returns
+// to broker without processing is rejected with requeue.
+type AcknowledgingBroker interface {
+	AckTaskMessage(ctx context.Context, message *TaskMessage) error
+	NackTaskMessage(ctx context.Context, message *TaskMessage, requeue bool) error
 }
 
 // CeleryBackend is interface for celery backend database
@@ -32,9 +60,9 @@ type CeleryBackend interface {
 // NewCeleryClient creates new celery client
 func NewCeleryClient(broker CeleryBroker, backend CeleryBackend, numWorkers int) (*CeleryClient, error) {
 	return &CeleryClient{
-		broker,
-		backend,
-		NewCeleryWorker(broker, backend, numWorkers),
+		broker:  broker,
+		backend: backend,
+		worker:  NewCeleryWorker(broker, backend, numWorkers),
 	}, nil
 }
 
@@ -43,6 +71,129 @@ func (cc *CeleryClient) Register(name string, task interface{}) {
 	cc.worker.Register(name, task)
 }
 
+// RegisterFactory registers task created by factory for every task message
+func (cc *CeleryClient) RegisterFactory(name string, factory CeleryTaskFactory) {
+	cc.worker.RegisterFactory(name, factory)
+}
+
+// UsePublishMiddleware appends middlewares wrapping publishing of tasks
+func (cc *CeleryClient) UsePublishMiddleware(middlewares ...PublishMiddleware) {
+	cc.middlewareLock.Lock()
+	cc.publishMiddlewares = append(cc.publishMiddlewares, middlewares...)
+	cc.middlewareLock.Unlock()
+}
+
+// UseExecuteMiddleware appends middlewares wrapping execution of tasks by worker
+func (cc *CeleryClient) UseExecuteMiddleware(middlewares ...ExecuteMiddleware) {
+	cc.worker.UseExecuteMiddleware(middlewares...)
+}
+
+// SetRoutes sets routers selecting queue, exchange, routing key and priority of published tasks
+// First router returning route applies, queue given explicitly to DelayKwargs takes precedence.
+func (cc *CeleryClient) SetRoutes(routers ...Router) {
+	cc.middlewareLock.Lock()
+	cc.routers = routers
+	cc.middlewareLock.Unlock()
+}
+
+// SetDeadLetterQueue sets dead letter queue of worker
+// Broker also receives dead letter queue if it provides SetDeadLetterQueue method.
+func (cc *CeleryClient) SetDeadLetterQueue(dlq DeadLetterQueue) {
+	cc.worker.SetDeadLetterQueue(dlq)
+	if setter, ok := cc.broker.(deadLetterSetter); ok {
+		setter.SetDeadLetterQueue(dlq)
+	}
+}
+
+// SetUnregisteredTaskPolicy sets handling of messages of tasks not registered by worker
+func (cc *CeleryClient) SetUnregisteredTaskPolicy(policy UnregisteredTaskPolicy) {
+	cc.worker.SetUnregisteredTaskPolicy(policy)
+}
+
+// ReplayDeadLetter sends dead-lettered message back to its original queue
+func (cc *CeleryClient) ReplayDeadLetter(ctx context.Context, timeout time.Duration, id string) error {
+	dlq := cc.worker.getDeadLetterQueue()
+	if dlq == nil {
+		return fmt.Errorf("dead letter queue is not configured")
+	}
+	return ReplayDeadLetter(ctx, dlq, cc.broker, timeout, id)
+}
+
+// SetPropagator sets propagator carrying context values in message headers
+// from published tasks into context of tasks executed by worker
+func (cc *CeleryClient) SetPropagator(propagator Propagator) {
+	cc.middlewareLock.Lock()
+	cc.propagator = propagator
+	cc.middlewareLock.Unlock()
+	cc.worker.SetPropagator(propagator)
+}
+
+// SetTracer sets tracer creating publish spans and spans of tasks executed by worker
+func (cc *CeleryClient) SetTracer(tracer Tracer) {
+	cc.middlewareLock.Lock()
+	cc.tracer = tracer
+	cc.middlewareLock.Unlock()
+	cc.worker.SetTracer(tracer)
+}
+
+// SetMetrics sets metrics collecting statistics of published tasks
+// and tasks executed by worker
+func (cc *CeleryClient) SetMetrics(metrics *Metrics) {
+	cc.middlewareLock.Lock()
+	cc.metrics = metrics
+	cc.middlewareLock.Unlock()
+	cc.worker.SetMetrics(metrics)
+}
+
+// SetLogger sets logger of worker
+// Broker and backend also receive logger if they provide SetLogger method.
+func (cc *CeleryClient) SetLogger(logger Logger) {
+	cc.worker.SetLogger(logger)
+	if setter, ok := cc.broker.(loggerSetter); ok {
+		setter.SetLogger(logger)
+	}
+	if setter, ok := cc.backend.(loggerSetter); ok {
+		setter.SetLogger(logger)
+	}
+}
+
+// OnTaskPrerun registers handler called before task is executed by worker
+func (cc *CeleryClient) OnTaskPrerun(handler TaskSignalHandler) {
+	cc.worker.OnTaskPrerun(handler)
+}
+
+// OnTaskPostrun registers handler called after task is executed by worker
+func (cc *CeleryClient) OnTaskPostrun(handler TaskPostrunHandler) {
+	cc.worker.OnTaskPostrun(handler)
+}
+
+// OnTaskFailure registers handler called when task executed by worker fails
+func (cc *CeleryClient) OnTaskFailure(handler TaskErrorHandler) {
+	cc.worker.OnTaskFailure(handler)
+}
+
+// OnTaskRetry registers handler called when task executed by worker is retried
+func (cc *CeleryClient) OnTaskRetry(handler TaskErrorHandler) {
+	cc.worker.OnTaskRetry(handler)
+}
+
+// OnBeforeTaskPublish registers handler called right before task is sent to broker
+func (cc *CeleryClient) OnBeforeTaskPublish(handler TaskSignalHandler) {
+	cc.middlewareLock.Lock()
+	cc.beforePublishSignal = append(cc.beforePublishSignal, handler)
+	cc.middlewareLock.Unlock()
+}
+
+// SetTimeLimits sets default soft and hard time limits for all tasks
+func (cc *CeleryClient) SetTimeLimits(soft, hard time.Duration) {
+	cc.worker.SetTimeLimits(soft, hard)
+}
+
+// SetTaskTimeLimits sets soft and hard time limits for given task
+func (cc *CeleryClient) SetTaskTimeLimits(name string, soft, hard time.Duration) {
+	cc.worker.SetTaskTimeLimits(name, soft, hard)
+}
+
 // StartWorkerWithContext starts celery workers with given parent context
 func (cc *CeleryClient) StartWorkerWithContext(ctx context.Context, timeout time.Duration) {
 	cc.worker.StartWorkerWithContext(ctx, timeout)
@@ -63,6 +214,36 @@ func (cc *CeleryClient) WaitForStopWorker() {
 	cc.worker.StopWait()
 }
 
+// SetAutoscale enables autoscaling of celery workers between min and max
+func (cc *CeleryClient) SetAutoscale(autoscale Autoscale) {
+	cc.worker.SetAutoscale(autoscale)
+}
+
+// SetPrefetchMultiplier sets how many messages per celery worker are prefetched from broker
+func (cc *CeleryClient) SetPrefetchMultiplier(multiplier int) {
+	cc.worker.SetPrefetchMultiplier(multiplier)
+}
+
+// Grow adds n celery workers
+func (cc *CeleryClient) Grow(n int) {
+	cc.worker.Grow(n)
+}
+
+// Shrink stops n celery workers
+func (cc *CeleryClient) Shrink(n int) {
+	cc.worker.Shrink(n)
+}
+
+// Shutdown gracefully stops celery workers waiting for running tasks until ctx is done
+func (cc *CeleryClient) Shutdown(ctx context.Context) error {
+	return cc.worker.Shutdown(ctx)
+}
+
+// ShutdownOnSignal blocks until one of signals is received and gracefully stops celery workers
+func (cc *CeleryClient) ShutdownOnSignal(ctx context.Context, grace time.Duration, signals ...os.Signal) error {
+	return cc.worker.ShutdownOnSignal(ctx, grace, signals...)
+}
+
 // Delay gets asynchronous result
 func (cc *CeleryClient) Delay(ctx context.Context, timeout time.Duration, task string, args ...interface{}) (*AsyncResult, error) {
 	celeryTask := getTaskMessage(ctx, task)
@@ -79,21 +260,27 @@ func (cc *CeleryClient) DelayKwargs(ctx context.Context, timeout time.Duration,
 
 func (cc *CeleryClient) delay(ctx context.Context, timeout time.Duration, task *TaskMessage, queue ...string) (*AsyncResult, error) {
 	defer releaseTaskMessage(task)
-	encodedMessage, err := task.Encode()
-	if err != nil {
-		return nil, err
-	}
-	celeryMessage := getCeleryMessage(encodedMessage)
 
+	cc.middlewareLock.RLock()
+	routers := cc.routers
+	cc.middlewareLock.RUnlock()
 	if len(queue) > 0 && queue[0] != `` {
-		celeryMessage.Properties.DeliveryInfo.Exchange = ``
-		celeryMessage.Properties.DeliveryInfo.RoutingKey = queue[0]
+		task.DeliveryInfo = &CeleryDeliveryInfo{
+			Exchange:   ``,
+			RoutingKey: queue[0],
+			Queue:      queue[0],
+		}
+	} else if route := routeTask(routers, task); route != nil {
+		task.DeliveryInfo = route.deliveryInfo()
 	}
 
-	defer releaseCeleryMessage(celeryMessage)
+	cc.middlewareLock.RLock()
+	publish := chainPublish(func(ctx context.Context, message *TaskMessage) error {
+		return cc.publish(ctx, timeout, message)
+	}, cc.publishMiddlewares)
+	cc.middlewareLock.RUnlock()
 
-	err = cc.broker.SendCeleryMessage(ctx, timeout, celeryMessage)
-	if err != nil {
+	if err := publish(ctx, task); err != nil {
 		return nil, err
 	}
 	return &AsyncResult{
@@ -102,6 +289,66 @@ func (cc *CeleryClient) delay(ctx context.Context, timeout time.Duration, task *
 	}, nil
 }
 
+// publish sends task message to broker within publish span
+// after injecting context into headers and notifying before_task_publish handlers
+func (cc *CeleryClient) publish(ctx context.Context, timeout time.Duration, task *TaskMessage) error {
+	cc.middlewareLock.RLock()
+	handlers, propagator, tracer, metrics := cc.beforePublishSignal, cc.propagator, cc.tracer, cc.metrics
+	cc.middlewareLock.RUnlock()
+
+	ctx, span := startSpan(ctx, tracer, "publish", SpanKindProducer, task)
+	defer span.End()
+	if propagator != nil {
+		if task.Headers == nil {
+			task.Headers = make(map[string]interface{})
+		}
+		propagator.Inject(ctx, task.Headers)
+	}
+	if metrics != nil {
+		if task.Headers == nil {
+			task.Headers = make(map[string]interface{})
+		}
+		task.Headers[publishedAtHeader] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	for _, handler := range handlers {
+		handler(ctx, task)
+	}
+	err := sendTaskMessage(ctx, cc.broker, timeout, task)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	metrics.taskPublished(task)
+	return nil
+}
+
+// sendTaskMessage encodes task message into CeleryMessage and sends it to broker
+func sendTaskMessage(ctx context.Context, broker CeleryBroker, timeout time.Duration, task *TaskMessage) error {
+	celeryMessage, err := encodeCeleryMessage(task)
+	if err != nil {
+		return err
+	}
+	defer releaseCeleryMessage(celeryMessage)
+	return broker.SendCeleryMessage(ctx, timeout, celeryMessage)
+}
+
+// encodeCeleryMessage wraps encoded task message in celery message with its headers and delivery info
+// Returned message should be released with releaseCeleryMessage.
+func encodeCeleryMessage(task *TaskMessage) (*CeleryMessage, error) {
+	encodedMessage, err := task.Encode()
+	if err != nil {
+		return nil, err
+	}
+	celeryMessage := getCeleryMessage(encodedMessage)
+	if len(task.Headers) > 0 {
+		celeryMessage.Headers = task.Headers
+	}
+	if task.DeliveryInfo != nil {
+		celeryMessage.Properties.DeliveryInfo = *task.DeliveryInfo
+	}
+	return celeryMessage, nil
+}
+
 // CeleryTask is an interface that represents actual task
 // Passing CeleryTask interface instead of function pointer
 // avoids reflection and may have performance gain.
@@ -115,6 +362,22 @@ type CeleryTask interface {
 	RunTask() (interface{}, error)
 }
 
+// CeleryTaskFactory creates new CeleryTask instance
+// Returned instance may also implement CeleryTaskWithContext.
+type CeleryTaskFactory func() CeleryTask
+
+// CeleryTaskWithContext is CeleryTask that receives context of its execution
+// Context is cancelled when worker stops or soft time limit is exceeded
+// and carries TaskRequest obtained using TaskRequestFromContext().
+type CeleryTaskWithContext interface {
+
+	// ParseKwargs - define a method to parse kwargs
+	ParseKwargs(map[string]interface{}) error
+
+	// RunTaskWithContext - define a method for execution with context
+	RunTaskWithContext(ctx context.Context) (interface{}, error)
+}
+
 // AsyncResult represents pending result
 type AsyncResult struct {
 	taskID  string
@@ -122,8 +385,14 @@ type AsyncResult struct {
 	result  *ResultMessage
 }
 
+// TaskID returns ID of the task
+func (ar *AsyncResult) TaskID() string {
+	return ar.taskID
+}
+
 // Get gets actual result from backend
-// It blocks for period of time set by timeout and returns error if unavailable
+// It blocks for period of time set by timeout and returns error if unavailable.
+// Failure of the task is returned as soon as it is stored, see ErrTaskFailed.
 func (ar *AsyncResult) Get(ctx context.Context, timeout time.Duration) (interface{}, error) {
 	ticker := time.NewTicker(50 * time.Millisecond)
 	timeoutChan := time.After(timeout)
@@ -134,6 +403,9 @@ func (ar *AsyncResult) Get(ctx context.Context, timeout time.Duration) (interfac
 			return nil, err
 		case <-ticker.C:
 			val, err := ar.AsyncGet(ctx)
+			if errors.Is(err, ErrTaskFailed) {
+				return nil, err
+			}
 			if err != nil {
 				continue
 			}
@@ -142,6 +414,10 @@ func (ar *AsyncResult) Get(ctx context.Context, timeout time.Duration) (interfac
 	}
 }
 
+// ErrTaskFailed is wrapped by error of AsyncResult of failed task
+// together with *ExceptionInfo describing the failure.
+var ErrTaskFailed = errors.New("task failed")
+
 // AsyncGet gets actual result from backend and returns nil if not available
 func (ar *AsyncResult) AsyncGet(ctx context.Context) (interface{}, error) {
 	if ar.result != nil {
@@ -154,6 +430,9 @@ func (ar *AsyncResult) AsyncGet(ctx context.Context) (interface{}, error) {
 	if val == nil {
 		return nil, err
 	}
+	if val.Status == "FAILURE" {
+		return nil, fmt.Errorf("%w: %w", ErrTaskFailed, resultError(val))
+	}
 	if val.Status != "SUCCESS" {
 		return nil, fmt.Errorf("error response status %v", val)
 	}
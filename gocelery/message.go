@@ -191,6 +191,7 @@ type ResultMessage struct {
 }
 
 func (rm *ResultMessage) reset() {
+	rm.Status = "SUCCESS"
 	rm.Result = nil
 }
 
@@ -210,6 +211,18 @@ func getResultMessage(val interface{}) *ResultMessage {
 	return msg
 }
 
+// getFailureResultMessage returns result of task failed with given python exception
+func getFailureResultMessage(excType, excModule, excMessage string) *ResultMessage {
+	msg := resultMessagePool.Get().(*ResultMessage)
+	msg.Status = "FAILURE"
+	msg.Result = map[string]interface{}{
+		"exc_type":    excType,
+		"exc_message": []interface{}{excMessage},
+		"exc_module":  excModule,
+	}
+	return msg
+}
+
 func getReflectionResultMessage(val *reflect.Value) *ResultMessage {
 	msg := resultMessagePool.Get().(*ResultMessage)
 	msg.Result = GetRealValue(val)
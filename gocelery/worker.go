@@ -159,24 +159,20 @@ func runTaskFunc(taskFunc *reflect.Value, message *TaskMessage) (*ResultMessage,
 	numArgs := taskFunc.Type().NumIn()
 	messageNumArgs := len(message.Args)
 	if numArgs != messageNumArgs {
-		return nil, fmt.Errorf("Number of task arguments %d does not match number of message arguments %d", numArgs, messageNumArgs)
+		err := fmt.Errorf("Number of task arguments %d does not match number of message arguments %d", numArgs, messageNumArgs)
+		return getFailureResultMessage("TypeError", "builtins", err.Error()), nil
 	}
 
 	// construct arguments
+	// json decoded values are converted to types of function parameters
 	in := make([]reflect.Value, messageNumArgs)
 	for i, arg := range message.Args {
-		origType := taskFunc.Type().In(i).Kind()
-		msgType := reflect.TypeOf(arg).Kind()
-		// special case - convert float64 to int if applicable
-		// this is due to json limitation where all numbers are converted to float64
-		if origType == reflect.Int && msgType == reflect.Float64 {
-			arg = int(arg.(float64))
-		}
-		if origType == reflect.Float32 && msgType == reflect.Float64 {
-			arg = float32(arg.(float64))
+		val, err := ConvertArgument(arg, taskFunc.Type().In(i))
+		if err != nil {
+			err = fmt.Errorf("task %s argument %d: %v", message.Task, i, err)
+			return getFailureResultMessage("TypeError", "builtins", err.Error()), nil
 		}
-
-		in[i] = reflect.ValueOf(arg)
+		in[i] = val
 	}
 
 	// call method
@@ -152,6 +152,69 @@ func TestWorkerExpiredTask(t *testing.T) {
 	}
 }
 
+// TestWorkerRunTaskArguments tests conversion of json decoded arguments
+// and failures reported for arguments not matching the task
+func TestWorkerRunTaskArguments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		task     interface{}
+		args     []interface{}
+		status   string
+		expected interface{}
+	}{
+		{
+			name:     "float32 argument",
+			task:     func(a float32, b float32) float32 { return a + b },
+			args:     []interface{}{1.5, 2.0},
+			status:   "SUCCESS",
+			expected: 3.5,
+		},
+		{
+			name:     "list argument",
+			task:     func(values []int) int { return len(values) },
+			args:     []interface{}{[]interface{}{1.0, 2.0, 3.0}},
+			status:   "SUCCESS",
+			expected: int64(3),
+		},
+		{
+			name:   "mismatched argument type",
+			task:   add,
+			args:   []interface{}{"1", 2.0},
+			status: "FAILURE",
+		},
+		{
+			name:   "mismatched number of arguments",
+			task:   add,
+			args:   []interface{}{1.0},
+			status: "FAILURE",
+		},
+	}
+	for _, tc := range testCases {
+		celeryWorker := NewCeleryWorker(nil, nil, 1)
+		celeryWorker.Register("task", tc.task)
+		resultMsg, err := celeryWorker.RunTask(&TaskMessage{
+			ID:   uuid.Must(uuid.NewV4()).String(),
+			Task: "task",
+			Args: tc.args,
+		})
+		if err != nil {
+			t.Errorf("test '%s': failed to run task: %v", tc.name, err)
+			continue
+		}
+		if resultMsg.Status != tc.status {
+			t.Errorf("test '%s': expected status %s, got %s", tc.name, tc.status, resultMsg.Status)
+		}
+		if tc.status == "FAILURE" {
+			if excInfo, ok := resultMsg.Result.(map[string]interface{}); !ok || excInfo["exc_type"] != "TypeError" {
+				t.Errorf("test '%s': expected TypeError, got %v", tc.name, resultMsg.Result)
+			}
+		} else if !reflect.DeepEqual(resultMsg.Result, tc.expected) {
+			t.Errorf("test '%s': expected result %v, got %v", tc.name, tc.expected, resultMsg.Result)
+		}
+		releaseResultMessage(resultMsg)
+	}
+}
+
 // TestWorkerNumWorkers ensures correct number of workers is set
 func TestWorkerNumWorkers(t *testing.T) {
 	testCases := []struct {
@@ -0,0 +1,110 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger is structured logger used by clients, workers and brokers.
+// Arguments are alternating keys and values as accepted by log/slog.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+	With(args ...any) Logger
+}
+
+// NewSlogLogger creates Logger writing records to given slog.Handler
+// Nil handler writes to handler of slog.Default() at the time of logging.
+func NewSlogLogger(handler slog.Handler) Logger {
+	return &slogLogger{handler: handler}
+}
+
+// NopLogger returns Logger discarding all records
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+// loggerSetter is implemented by brokers and backends accepting logger
+type loggerSetter interface {
+	SetLogger(logger Logger)
+}
+
+// defaultLogger is used unless logger is configured
+var defaultLogger = NewSlogLogger(nil)
+
+// loggerOrDefault returns given logger or default logger if it is nil
+func loggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return defaultLogger
+	}
+	return logger
+}
+
+type slogLogger struct {
+	handler slog.Handler
+	args    []any
+}
+
+func (l *slogLogger) log(level slog.Level, msg string, args []any) {
+	logger := slog.Default()
+	if l.handler != nil {
+		logger = slog.New(l.handler)
+	}
+	if len(l.args) > 0 {
+		args = append(append([]any(nil), l.args...), args...)
+	}
+	logger.Log(context.Background(), level, msg, args...)
+}
+
+func (l *slogLogger) Debug(msg string, args ...any) {
+	l.log(slog.LevelDebug, msg, args)
+}
+
+func (l *slogLogger) Info(msg string, args ...any) {
+	l.log(slog.LevelInfo, msg, args)
+}
+
+func (l *slogLogger) Warn(msg string, args ...any) {
+	l.log(slog.LevelWarn, msg, args)
+}
+
+func (l *slogLogger) Error(msg string, args ...any) {
+	l.log(slog.LevelError, msg, args)
+}
+
+func (l *slogLogger) With(args ...any) Logger {
+	return &slogLogger{
+		handler: l.handler,
+		args:    append(append([]any(nil), l.args...), args...),
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+func (nopLogger) With(args ...any) Logger       { return nopLogger{} }
+
+type workerIDKey struct{}
+
+// contextWithWorkerID returns copy of parent context carrying ID of worker goroutine
+func contextWithWorkerID(ctx context.Context, workerID int) context.Context {
+	return context.WithValue(ctx, workerIDKey{}, workerID)
+}
+
+// taskLogger returns logger annotated with task fields and worker ID carried by ctx
+func taskLogger(ctx context.Context, logger Logger, message *TaskMessage) Logger {
+	args := []any{"task_id", message.ID, "task_name", message.Task, "queue", messageQueue(message)}
+	if workerID, ok := ctx.Value(workerIDKey{}).(int); ok {
+		args = append(args, "worker_id", workerID)
+	}
+	return loggerOrDefault(logger).With(args...)
+}
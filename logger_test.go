@@ -0,0 +1,147 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is bytes.Buffer safe for concurrent writes
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+// records decodes json log records written to buffer
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	b.Lock()
+	defer b.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode log record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestWorkerLogger tests structured fields of events logged by worker
+func TestWorkerLogger(t *testing.T) {
+	output := &syncBuffer{}
+	cli := newLoopbackClient()
+	cli.SetLogger(NewSlogLogger(slog.NewJSONHandler(output, nil)))
+	cli.Register("explode", func() int {
+		panic("boom")
+	})
+
+	ctx := context.Background()
+	asyncResult, err := cli.DelayKwargs(ctx, TIMEOUT, "explode", nil, "panics")
+	if err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+
+	records := output.records(t)
+	if len(records) != 1 {
+		t.Fatalf("expected single log record, got %d: %v", len(records), records)
+	}
+	expected := map[string]interface{}{
+		"level":     "ERROR",
+		"msg":       "task panicked",
+		"task_id":   asyncResult.TaskID(),
+		"task_name": "explode",
+		"queue":     "panics",
+		"panic":     "boom",
+	}
+	for key, value := range expected {
+		if records[0][key] != value {
+			t.Errorf("test '%s': expected %v for %s, got %v", "panic", value, key, records[0][key])
+		}
+	}
+	if stack, _ := records[0]["stack"].(string); !strings.Contains(stack, "goroutine") {
+		t.Errorf("test '%s': expected stack trace, got %q", "panic", stack)
+	}
+}
+
+// TestTaskLogger tests fields attached to task logger
+func TestTaskLogger(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		expected map[string]interface{}
+	}{
+		{
+			name: "without worker",
+			ctx:  context.Background(),
+			expected: map[string]interface{}{
+				"task_id":   "task-id",
+				"task_name": "add",
+				"queue":     "celery",
+				"level":     "WARN",
+			},
+		},
+		{
+			name: "with worker",
+			ctx:  contextWithWorkerID(context.Background(), 3),
+			expected: map[string]interface{}{
+				"task_id":   "task-id",
+				"task_name": "add",
+				"queue":     "celery",
+				"worker_id": float64(3),
+				"attempt":   float64(2),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		output := &syncBuffer{}
+		message := getTaskMessage(tc.ctx, "add")
+		message.ID = "task-id"
+		logger := NewSlogLogger(slog.NewJSONHandler(output, nil))
+		taskLogger(tc.ctx, logger, message).With("attempt", 2).Warn("event")
+		message.reset()
+
+		records := output.records(t)
+		if len(records) != 1 {
+			t.Errorf("test '%s': expected single log record, got %d", tc.name, len(records))
+			continue
+		}
+		for key, value := range tc.expected {
+			if records[0][key] != value {
+				t.Errorf("test '%s': expected %v for %s, got %v", tc.name, value, key, records[0][key])
+			}
+		}
+		if _, ok := records[0]["worker_id"]; ok != (tc.expected["worker_id"] != nil) {
+			t.Errorf("test '%s': unexpected worker_id presence in %v", tc.name, records[0])
+		}
+	}
+}
+
+// TestNopLogger tests that no-op logger discards records
+func TestNopLogger(t *testing.T) {
+	logger := NopLogger().With("key", "value")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+	if loggerOrDefault(nil) != defaultLogger {
+		t.Errorf("nil logger should fall back to default logger")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemoryCeleryBackend is celery backend keeping results in memory
+// Results are copied through json encoding, the same way as by other backends.
+// It is safe for concurrent use.
+type MemoryCeleryBackend struct {
+	lock    sync.RWMutex
+	results map[string][]byte
+}
+
+// NewMemoryCeleryBackend creates new MemoryCeleryBackend
+func NewMemoryCeleryBackend() *MemoryCeleryBackend {
+	return &MemoryCeleryBackend{
+		results: map[string][]byte{},
+	}
+}
+
+// GetResult returns result of given task
+func (b *MemoryCeleryBackend) GetResult(ctx context.Context, taskID string) (*ResultMessage, error) {
+	b.lock.RLock()
+	resBytes, ok := b.results[taskID]
+	b.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("result not available")
+	}
+	var resultMessage ResultMessage
+	if err := json.Unmarshal(resBytes, &resultMessage); err != nil {
+		return nil, err
+	}
+	return &resultMessage, nil
+}
+
+// SetResult stores result of given task
+func (b *MemoryCeleryBackend) SetResult(ctx context.Context, taskID string, result *ResultMessage) error {
+	resBytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.results[taskID] = resBytes
+	b.lock.Unlock()
+	return nil
+}
+
+// DeleteResult removes result of given task
+// It never fails, error is returned for compatibility with other backends.
+func (b *MemoryCeleryBackend) DeleteResult(ctx context.Context, taskID string) error {
+	b.lock.Lock()
+	delete(b.results, taskID)
+	b.lock.Unlock()
+	return nil
+}
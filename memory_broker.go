@@ -0,0 +1,348 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryCeleryBroker is celery broker keeping messages in memory
+// Messages are routed to queue named by routing key of their delivery info.
+// Higher priority messages are delivered first, messages with ETA in the future
+// are held until ETA. Delivered message is kept unacknowledged until it is
+// acknowledged or rejected, message not acknowledged within visibility timeout
+// is delivered again. Message pending when consumer stops is returned to front of its queue.
+// It is safe for concurrent use.
+type MemoryCeleryBroker struct {
+	lock       sync.Mutex
+	queues     map[string]*memoryQueue
+	consume    []string
+	sequence   uint64
+	notify     chan struct{}
+	unacked    map[*TaskMessage]*memoryMessage
+	visibility time.Duration
+}
+
+// defaultVisibilityTimeout is time after which unacknowledged message is delivered again
+// It is the same as default visibility timeout of kombu redis transport.
+const defaultVisibilityTimeout = time.Hour
+
+// NewMemoryCeleryBroker creates new MemoryCeleryBroker consuming given queues
+// Queue "celery" is consumed if no queues are given.
+func NewMemoryCeleryBroker(queues ...string) *MemoryCeleryBroker {
+	if len(queues) == 0 {
+		queues = []string{"celery"}
+	}
+	return &MemoryCeleryBroker{
+		queues:     map[string]*memoryQueue{},
+		consume:    queues,
+		notify:     make(chan struct{}),
+		unacked:    map[*TaskMessage]*memoryMessage{},
+		visibility: defaultVisibilityTimeout,
+	}
+}
+
+// SetVisibilityTimeout sets time after which unacknowledged message is delivered again
+func (b *MemoryCeleryBroker) SetVisibilityTimeout(timeout time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.visibility = timeout
+	b.broadcastLocked()
+}
+
+// SendCeleryMessage sends CeleryMessage to queue of its delivery info
+// Message is copied through json encoding, the same way as by other brokers.
+func (b *MemoryCeleryBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	queue := message.Properties.DeliveryInfo.queueName()
+	taskMessage, err := decodeMemoryMessage(data, queue)
+	if err != nil {
+		return err
+	}
+	eta, _ := taskMessage.etaTime()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.sequence++
+	b.queue(queue).push(&memoryMessage{
+		message:  taskMessage,
+		data:     data,
+		priority: taskMessage.DeliveryInfo.Priority,
+		sequence: b.sequence,
+		eta:      eta,
+	})
+	b.broadcastLocked()
+	return nil
+}
+
+// GetTaskMessage retrieves task message from consumed queues waiting up to timeout
+func (b *MemoryCeleryBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	message, err := b.next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no message available: %w", err)
+	}
+	return message, nil
+}
+
+// ConsumeTaskMessages streams task messages from consumed queues
+func (b *MemoryCeleryBroker) ConsumeTaskMessages(ctx context.Context, timeout time.Duration) (<-chan *TaskMessage, error) {
+	taskMessages := make(chan *TaskMessage)
+	go func() {
+		defer close(taskMessages)
+		for {
+			message, err := b.next(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case taskMessages <- message:
+			case <-ctx.Done():
+				b.NackTaskMessage(context.WithoutCancel(ctx), message, true)
+				return
+			}
+		}
+	}()
+	return taskMessages, nil
+}
+
+// AckTaskMessage removes delivered message from broker
+func (b *MemoryCeleryBroker) AckTaskMessage(ctx context.Context, message *TaskMessage) error {
+	_, err := b.takeUnacked(message)
+	return err
+}
+
+// NackTaskMessage returns delivered message to front of its queue if requeue is set,
+// otherwise message is removed from broker
+func (b *MemoryCeleryBroker) NackTaskMessage(ctx context.Context, message *TaskMessage, requeue bool) error {
+	unacked, err := b.takeUnacked(message)
+	if err != nil || !requeue {
+		return err
+	}
+	b.requeue(unacked)
+	return nil
+}
+
+// takeUnacked removes delivered message from unacknowledged messages
+func (b *MemoryCeleryBroker) takeUnacked(message *TaskMessage) (*memoryMessage, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	unacked, ok := b.unacked[message]
+	if !ok {
+		return nil, fmt.Errorf("task %s is not awaiting acknowledgement", message.ID)
+	}
+	delete(b.unacked, message)
+	return unacked, nil
+}
+
+// QueueDepth returns number of messages waiting in given queue including messages held until ETA
+func (b *MemoryCeleryBroker) QueueDepth(ctx context.Context, queue string) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	q, ok := b.queues[queue]
+	if !ok {
+		return 0, nil
+	}
+	return int64(q.ready.Len() + q.delayed.Len()), nil
+}
+
+// next waits until message is ready in one of consumed queues or ctx is done
+// Returned message is decoded again from queued message, so that changes of delivered
+// message do not reach its redelivery, and it awaits acknowledgement.
+func (b *MemoryCeleryBroker) next(ctx context.Context) (*TaskMessage, error) {
+	for {
+		b.lock.Lock()
+		now := time.Now()
+		redeliverWait := b.redeliverLocked(now)
+		message, wait := b.popLocked(now)
+		if message != nil {
+			delivered, err := decodeMemoryMessage(message.data, message.message.DeliveryInfo.Queue)
+			if err != nil {
+				b.lock.Unlock()
+				return nil, err
+			}
+			message.deadline = now.Add(b.visibility)
+			b.unacked[delivered] = message
+			b.lock.Unlock()
+			return delivered, nil
+		}
+		if redeliverWait > 0 && (wait == 0 || redeliverWait < wait) {
+			wait = redeliverWait
+		}
+		notify := b.notify
+		b.lock.Unlock()
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-notify:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// redeliverLocked returns messages not acknowledged within visibility timeout to their queues
+// It returns time until next unacknowledged message expires, zero if there is none.
+func (b *MemoryCeleryBroker) redeliverLocked(now time.Time) time.Duration {
+	var wait time.Duration
+	for delivered, message := range b.unacked {
+		if untilDeadline := message.deadline.Sub(now); untilDeadline > 0 {
+			if wait == 0 || untilDeadline < wait {
+				wait = untilDeadline
+			}
+			continue
+		}
+		delete(b.unacked, delivered)
+		b.queue(message.message.DeliveryInfo.queueName()).push(message)
+	}
+	return wait
+}
+
+// popLocked pops highest priority ready message of consumed queues
+// If none is ready, it returns time until earliest ETA, zero if there is none.
+func (b *MemoryCeleryBroker) popLocked(now time.Time) (*memoryMessage, time.Duration) {
+	var best *memoryQueue
+	var wait time.Duration
+	for _, name := range b.consume {
+		q, ok := b.queues[name]
+		if !ok {
+			continue
+		}
+		if untilETA := q.promote(now); untilETA > 0 && (wait == 0 || untilETA < wait) {
+			wait = untilETA
+		}
+		if q.ready.Len() > 0 && (best == nil || q.ready.less(q.ready[0], best.ready[0])) {
+			best = q
+		}
+	}
+	if best == nil {
+		return nil, wait
+	}
+	return heap.Pop(&best.ready).(*memoryMessage), 0
+}
+
+// requeue returns message to front of its queue
+func (b *MemoryCeleryBroker) requeue(message *memoryMessage) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.queue(message.message.DeliveryInfo.queueName()).push(message)
+	b.broadcastLocked()
+}
+
+// queue returns queue with given name creating it if necessary
+func (b *MemoryCeleryBroker) queue(name string) *memoryQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// broadcastLocked wakes up all waiting consumers
+func (b *MemoryCeleryBroker) broadcastLocked() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// decodeMemoryMessage decodes task message of json encoded celery message sent to queue
+func decodeMemoryMessage(data []byte, queue string) (*TaskMessage, error) {
+	var message CeleryMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	taskMessage, err := message.decodeTaskMessage()
+	if err != nil {
+		return nil, err
+	}
+	taskMessage.DeliveryInfo.Queue = queue
+	return taskMessage, nil
+}
+
+// memoryMessage is task message waiting in memory queue
+type memoryMessage struct {
+	message  *TaskMessage
+	data     []byte // json encoded celery message decoded for each delivery
+	priority int
+	sequence uint64
+	eta      time.Time
+	deadline time.Time // when unacknowledged message is delivered again
+}
+
+// memoryQueue holds ready messages ordered by priority and messages waiting for ETA
+type memoryQueue struct {
+	ready   memoryHeap
+	delayed memoryHeap
+}
+
+func (q *memoryQueue) push(message *memoryMessage) {
+	if message.eta.After(time.Now()) {
+		heap.Push(&q.delayed, message)
+		return
+	}
+	message.eta = time.Time{}
+	heap.Push(&q.ready, message)
+}
+
+// promote moves messages which reached their ETA to ready messages
+// and returns time until next ETA, zero if no message is delayed.
+func (q *memoryQueue) promote(now time.Time) time.Duration {
+	for q.delayed.Len() > 0 {
+		next := q.delayed[0]
+		if next.eta.After(now) {
+			return next.eta.Sub(now)
+		}
+		heap.Pop(&q.delayed)
+		next.eta = time.Time{}
+		heap.Push(&q.ready, next)
+	}
+	return 0
+}
+
+// memoryHeap orders messages by ETA, then priority and then sequence
+// Ready messages have zero ETA, so they are ordered by priority.
+type memoryHeap []*memoryMessage
+
+func (h memoryHeap) less(a, b *memoryMessage) bool {
+	if !a.eta.Equal(b.eta) {
+		return a.eta.Before(b.eta)
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.sequence < b.sequence
+}
+
+func (h memoryHeap) Len() int            { return len(h) }
+func (h memoryHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h memoryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *memoryHeap) Push(x interface{}) { *h = append(*h, x.(*memoryMessage)) }
+func (h *memoryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	message := old[n-1]
+	*h = old[:n-1]
+	return message
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendMemoryTask sends task message with given delivery info and ETA to broker
+func sendMemoryTask(t *testing.T, broker CeleryBroker, name string, deliveryInfo *CeleryDeliveryInfo, eta *time.Time) {
+	message := getTaskMessage(context.Background(), name)
+	defer releaseTaskMessage(message)
+	message.DeliveryInfo = deliveryInfo
+	if eta != nil {
+		formatted := eta.UTC().Format(time.RFC3339Nano)
+		message.ETA = &formatted
+	}
+	if err := sendTaskMessage(context.Background(), broker, TIMEOUT, message); err != nil {
+		t.Fatalf("failed to send task %s: %v", name, err)
+	}
+}
+
+// TestMemoryBrokerOrder tests queues, priorities and ETA of memory broker
+func TestMemoryBrokerOrder(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryCeleryBroker("celery", "urgent")
+	eta := time.Now().Add(50 * time.Millisecond)
+	sendMemoryTask(t, broker, "delayed", nil, &eta)
+	sendMemoryTask(t, broker, "first", nil, nil)
+	sendMemoryTask(t, broker, "other", &CeleryDeliveryInfo{RoutingKey: "other"}, nil)
+	sendMemoryTask(t, broker, "second", &CeleryDeliveryInfo{RoutingKey: "celery"}, nil)
+	sendMemoryTask(t, broker, "important", &CeleryDeliveryInfo{RoutingKey: "urgent", Priority: 5}, nil)
+	sendMemoryTask(t, broker, "past", nil, &time.Time{})
+
+	if depth, _ := broker.QueueDepth(ctx, "celery"); depth != 4 {
+		t.Errorf("expected 4 messages in celery queue, got %d", depth)
+	}
+	var received []string
+	for i := 0; i < 5; i++ {
+		message, err := broker.GetTaskMessage(ctx, time.Second)
+		if err != nil {
+			t.Fatalf("failed to get message %d: %v", i, err)
+		}
+		received = append(received, message.Task)
+	}
+	expected := []string{"important", "first", "second", "past", "delayed"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected messages %v, got %v", expected, received)
+	}
+	if time.Now().Before(eta) {
+		t.Errorf("delayed message was delivered before its ETA")
+	}
+	if _, err := broker.GetTaskMessage(ctx, 0); err == nil {
+		t.Errorf("message of queue which is not consumed should not be delivered")
+	}
+	if depth, _ := broker.QueueDepth(ctx, "other"); depth != 1 {
+		t.Errorf("expected single message in other queue, got %d", depth)
+	}
+}
+
+// TestMemoryBrokerConsume tests returning undelivered message to queue when consumer stops
+func TestMemoryBrokerConsume(t *testing.T) {
+	broker := NewMemoryCeleryBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	taskMessages, err := broker.ConsumeTaskMessages(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to consume task messages: %v", err)
+	}
+	sendMemoryTask(t, broker, "first", nil, nil)
+	if message := <-taskMessages; message.Task != "first" {
+		t.Errorf("expected message first, got %s", message.Task)
+	}
+	sendMemoryTask(t, broker, "second", nil, nil)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	for message := range taskMessages {
+		t.Errorf("unexpected message %s delivered after consumer stopped", message.Task)
+	}
+	message, err := broker.GetTaskMessage(context.Background(), 0)
+	if err != nil || message.Task != "second" {
+		t.Errorf("expected undelivered message second to be requeued, got %v (%v)", message, err)
+	}
+}
+
+// TestMemoryBrokerBackend tests workers processing tasks concurrently with memory broker and backend
+func TestMemoryBrokerBackend(t *testing.T) {
+	cli, _ := NewCeleryClient(NewMemoryCeleryBroker(), NewMemoryCeleryBackend(), 4)
+	cli.Register("add", add)
+	ctx := context.Background()
+	cli.StartWorker(ctx, TIMEOUT)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			asyncResult, err := cli.Delay(ctx, TIMEOUT, "add", i, i)
+			if err != nil {
+				t.Errorf("test '%d': failed to send task: %v", i, err)
+				return
+			}
+			res, err := asyncResult.Get(ctx, 5*time.Second)
+			if err != nil || res != float64(2*i) {
+				t.Errorf("test '%d': expected result %d, got %v (%v)", i, 2*i, res, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	cli.StopWorker()
+	broker := cli.broker.(*MemoryCeleryBroker)
+	broker.lock.Lock()
+	if len(broker.unacked) != 0 {
+		t.Errorf("expected processed messages to be acknowledged, %d left", len(broker.unacked))
+	}
+	broker.lock.Unlock()
+}
+
+// TestMemoryBrokerAck tests acknowledging, rejecting and redelivering messages
+func TestMemoryBrokerAck(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryCeleryBroker()
+	broker.SetVisibilityTimeout(50 * time.Millisecond)
+
+	sendMemoryTask(t, broker, "acked", nil, nil)
+	message, err := broker.GetTaskMessage(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get message: %v", err)
+	}
+	if depth, _ := broker.QueueDepth(ctx, "celery"); depth != 0 {
+		t.Errorf("delivered message should not be queued, got depth %d", depth)
+	}
+	if err := broker.AckTaskMessage(ctx, message); err != nil {
+		t.Errorf("failed to acknowledge message: %v", err)
+	}
+	if err := broker.AckTaskMessage(ctx, message); err == nil {
+		t.Errorf("message should not be acknowledged twice")
+	}
+
+	sendMemoryTask(t, broker, "rejected", nil, nil)
+	message, _ = broker.GetTaskMessage(ctx, TIMEOUT)
+	if err := broker.NackTaskMessage(ctx, message, true); err != nil {
+		t.Errorf("failed to reject message: %v", err)
+	}
+	message, err = broker.GetTaskMessage(ctx, TIMEOUT)
+	if err != nil || message.Task != "rejected" {
+		t.Fatalf("expected rejected message to be requeued, got %v (%v)", message, err)
+	}
+	if err := broker.NackTaskMessage(ctx, message, false); err != nil {
+		t.Errorf("failed to reject message: %v", err)
+	}
+	if message, err := broker.GetTaskMessage(ctx, 0); err == nil {
+		t.Errorf("message rejected without requeue should be removed, got %s", message.Task)
+	}
+
+	sendMemoryTask(t, broker, "abandoned", nil, nil)
+	abandoned, _ := broker.GetTaskMessage(ctx, TIMEOUT)
+	delivered := time.Now()
+	message, err = broker.GetTaskMessage(ctx, TIMEOUT)
+	if err != nil || message.Task != "abandoned" {
+		t.Fatalf("expected abandoned message to be delivered again, got %v (%v)", message, err)
+	}
+	if elapsed := time.Since(delivered); elapsed < 40*time.Millisecond {
+		t.Errorf("message delivered again after %v, before visibility timeout", elapsed)
+	}
+	if err := broker.AckTaskMessage(ctx, abandoned); err == nil {
+		t.Errorf("expired delivery should not be acknowledged")
+	}
+	if err := broker.AckTaskMessage(ctx, message); err != nil {
+		t.Errorf("failed to acknowledge redelivered message: %v", err)
+	}
+}
+
+// TestMemoryBrokerRedeliveryCopy tests that changes of delivered message do not reach its redelivery
+func TestMemoryBrokerRedeliveryCopy(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryCeleryBroker()
+	message := getTaskMessage(ctx, "nested")
+	defer releaseTaskMessage(message)
+	message.Args = []interface{}{[]interface{}{1, 2}}
+	message.Kwargs = map[string]interface{}{"options": map[string]interface{}{"deep": true}}
+	message.Headers = map[string]interface{}{"trace": "abc"}
+	if err := sendTaskMessage(ctx, broker, TIMEOUT, message); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+
+	delivered, err := broker.GetTaskMessage(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get message: %v", err)
+	}
+	expectedArgs := []interface{}{[]interface{}{float64(1), float64(2)}}
+	expectedKwargs := map[string]interface{}{"options": map[string]interface{}{"deep": true}}
+	delivered.Args[0].([]interface{})[0] = "changed"
+	delivered.Kwargs["options"].(map[string]interface{})["deep"] = false
+	delivered.Headers["trace"] = "changed"
+	delivered.DeliveryInfo.Priority = 9
+	if err := broker.NackTaskMessage(ctx, delivered, true); err != nil {
+		t.Fatalf("failed to reject message: %v", err)
+	}
+
+	redelivered, err := broker.GetTaskMessage(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get redelivered message: %v", err)
+	}
+	if !reflect.DeepEqual(redelivered.Args, expectedArgs) {
+		t.Errorf("expected args %v, got %v", expectedArgs, redelivered.Args)
+	}
+	if !reflect.DeepEqual(redelivered.Kwargs, expectedKwargs) {
+		t.Errorf("expected kwargs %v, got %v", expectedKwargs, redelivered.Kwargs)
+	}
+	if redelivered.Headers["trace"] != "abc" || redelivered.DeliveryInfo.Priority != 0 {
+		t.Errorf("expected unchanged headers and delivery info, got %v and %+v", redelivered.Headers, redelivered.DeliveryInfo)
+	}
+}
+
+// TestMemoryBackend tests storing and removing results in memory backend
+func TestMemoryBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryCeleryBackend()
+	if _, err := backend.GetResult(ctx, "missing"); err == nil {
+		t.Errorf("expected error for missing result")
+	}
+	result := getResultMessage(map[string]interface{}{"value": 1})
+	defer releaseResultMessage(result)
+	if err := backend.SetResult(ctx, "task", result); err != nil {
+		t.Fatalf("failed to set result: %v", err)
+	}
+	stored, err := backend.GetResult(ctx, "task")
+	if err != nil || !reflect.DeepEqual(stored.Result, map[string]interface{}{"value": float64(1)}) {
+		t.Errorf("unexpected stored result %+v (%v)", stored, err)
+	}
+	if err := backend.DeleteResult(ctx, "task"); err != nil {
+		t.Errorf("failed to delete result: %v", err)
+	}
+	if _, err := backend.GetResult(ctx, "task"); err == nil {
+		t.Errorf("expected error for deleted result")
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +33,11 @@ func (cm *CeleryMessage) reset() {
 	cm.Properties.CorrelationID = stringutil.UUID().String()
 	cm.Properties.ReplyTo = stringutil.UUID().String()
 	cm.Properties.DeliveryTag = stringutil.UUID().String()
+	cm.Properties.DeliveryInfo = CeleryDeliveryInfo{
+		Priority:   0,
+		RoutingKey: "celery",
+		Exchange:   "celery",
+	}
 }
 
 var celeryMessagePool = sync.Pool{
@@ -82,42 +89,88 @@ type CeleryDeliveryInfo struct {
 	Priority   int    `json:"priority"`
 	RoutingKey string `json:"routing_key"`
 	Exchange   string `json:"exchange"`
+	// Queue is used instead of routing key by brokers without exchanges
+	// such as redis, consumed messages carry queue they were received from
+	Queue string `json:"-"`
+}
+
+// queueName returns name of the queue message is pushed to by brokers without exchanges
+func (d *CeleryDeliveryInfo) queueName() string {
+	if d == nil {
+		return "celery"
+	}
+	if d.Queue != "" {
+		return d.Queue
+	}
+	if d.RoutingKey != "" {
+		return d.RoutingKey
+	}
+	return "celery"
 }
 
 // GetTaskMessage retrieve and decode task messages from broker
+// Messages which cannot be decoded are logged with default logger and nil is returned.
 func (cm *CeleryMessage) GetTaskMessage(ctx context.Context, timeout time.Duration) *TaskMessage {
+	taskMessage, err := cm.decodeTaskMessage()
+	if err != nil {
+		defaultLogger.Error("failed to decode task message", "error", err)
+		return nil
+	}
+	return taskMessage
+}
+
+// decodeTaskMessage decodes task message carried in body of celery message
+func (cm *CeleryMessage) decodeTaskMessage() (*TaskMessage, error) {
 	// ensure content-type is 'application/json'
 	if cm.ContentType != "application/json" {
-		log.Println("unsupported content type " + cm.ContentType)
-		return nil
+		return nil, fmt.Errorf("unsupported content type %s", cm.ContentType)
 	}
 	// ensure body encoding is base64
 	if cm.Properties.BodyEncoding != "base64" {
-		log.Println("unsupported body encoding " + cm.Properties.BodyEncoding)
-		return nil
+		return nil, fmt.Errorf("unsupported body encoding %s", cm.Properties.BodyEncoding)
 	}
 	// ensure content encoding is utf-8
 	if cm.ContentEncoding != "utf-8" {
-		log.Println("unsupported encoding " + cm.ContentEncoding)
-		return nil
+		return nil, fmt.Errorf("unsupported encoding %s", cm.ContentEncoding)
 	}
 	// decode body
 	taskMessage, err := DecodeTaskMessage(cm.Body)
 	if err != nil {
-		log.Println("failed to decode task message")
-		return nil
+		return nil, fmt.Errorf("failed to decode task message: %w", err)
 	}
-	return taskMessage
+	taskMessage.Headers = cm.Headers
+	deliveryInfo := cm.Properties.DeliveryInfo
+	taskMessage.DeliveryInfo = &deliveryInfo
+	return taskMessage, nil
 }
 
 // TaskMessage is celery-compatible message
 type TaskMessage struct {
-	ID      string                 `json:"id"`
-	Task    string                 `json:"task"`
-	Args    []interface{}          `json:"args"`
-	Kwargs  map[string]interface{} `json:"kwargs"`
-	Retries int                    `json:"retries"`
-	ETA     *string                `json:"eta"`
+	ID        string                 `json:"id"`
+	Task      string                 `json:"task"`
+	Args      []interface{}          `json:"args"`
+	Kwargs    map[string]interface{} `json:"kwargs"`
+	Retries   int                    `json:"retries"`
+	ETA       *string                `json:"eta"`
+	TimeLimit []*float64             `json:"timelimit,omitempty"`
+
+	// envelope of received message, not part of encoded task message
+	Headers      map[string]interface{} `json:"-"`
+	DeliveryInfo *CeleryDeliveryInfo    `json:"-"`
+}
+
+// etaTime returns time before which task must not be executed
+// Celery sends ETA in ISO 8601 format, time without zone is in UTC.
+func (tm *TaskMessage) etaTime() (time.Time, bool) {
+	if tm.ETA == nil || *tm.ETA == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if eta, err := time.Parse(layout, *tm.ETA); err == nil {
+			return eta, true
+		}
+	}
+	return time.Time{}, false
 }
 
 func (tm *TaskMessage) reset() {
@@ -125,6 +178,9 @@ func (tm *TaskMessage) reset() {
 	tm.Task = ""
 	tm.Args = nil
 	tm.Kwargs = nil
+	tm.TimeLimit = nil
+	tm.Headers = nil
+	tm.DeliveryInfo = nil
 }
 
 var taskMessagePool = sync.Pool{
@@ -144,6 +200,7 @@ func getTaskMessage(ctx context.Context, task string) *TaskMessage {
 	msg.Task = task
 	msg.Args = make([]interface{}, 0)
 	msg.Kwargs = make(map[string]interface{})
+	msg.Headers = make(map[string]interface{})
 	msg.ETA = nil
 	return msg
 }
@@ -187,9 +244,28 @@ type ResultMessage struct {
 }
 
 func (rm *ResultMessage) reset() {
+	rm.Status = "SUCCESS"
+	rm.Traceback = nil
 	rm.Result = nil
 }
 
+// ExceptionInfo is celery-compatible representation of task exception
+// stored as result of failed tasks
+type ExceptionInfo struct {
+	Type    string        `json:"exc_type"`
+	Message []interface{} `json:"exc_message"`
+	Module  string        `json:"exc_module"`
+}
+
+// Error implements error interface so that tasks can fail with specific exception type
+func (e *ExceptionInfo) Error() string {
+	messages := make([]string, len(e.Message))
+	for i, msg := range e.Message {
+		messages[i] = fmt.Sprint(msg)
+	}
+	return e.Type + ": " + strings.Join(messages, ", ")
+}
+
 var resultMessagePool = sync.Pool{
 	New: func() interface{} {
 		return &ResultMessage{
@@ -206,12 +282,72 @@ func getResultMessage(val interface{}) *ResultMessage {
 	return msg
 }
 
+func getFailureResultMessage(excType string, excModule string, args ...interface{}) *ResultMessage {
+	msg := resultMessagePool.Get().(*ResultMessage)
+	msg.Status = "FAILURE"
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	msg.Result = &ExceptionInfo{
+		Type:    excType,
+		Message: args,
+		Module:  excModule,
+	}
+	return msg
+}
+
+// getErrorResultMessage creates failure result from error returned by task
+// errors other than ExceptionInfo are reported as python Exception
+func getErrorResultMessage(err error) *ResultMessage {
+	var excInfo *ExceptionInfo
+	if errors.As(err, &excInfo) {
+		return getFailureResultMessage(excInfo.Type, excInfo.Module, excInfo.Message...)
+	}
+	return getFailureResultMessage("Exception", "builtins", err.Error())
+}
+
 func getReflectionResultMessage(val *reflect.Value) *ResultMessage {
 	msg := resultMessagePool.Get().(*ResultMessage)
 	msg.Result = GetRealValue(val)
 	return msg
 }
 
+// ensureEncodable replaces successful result which cannot be json encoded
+// with EncodeError failure so that the failure is reported to the caller
+func ensureEncodable(msg *ResultMessage) *ResultMessage {
+	if msg == nil || msg.Status != "SUCCESS" {
+		return msg
+	}
+	if _, err := json.Marshal(msg.Result); err != nil {
+		releaseResultMessage(msg)
+		return getFailureResultMessage("EncodeError", "kombu.exceptions", err.Error())
+	}
+	return msg
+}
+
+// resultError returns error describing failure result
+// Exception decoded from backend is returned as *ExceptionInfo as well.
+func resultError(msg *ResultMessage) error {
+	switch result := msg.Result.(type) {
+	case *ExceptionInfo:
+		return result
+	case map[string]interface{}:
+		if excType, ok := result["exc_type"].(string); ok {
+			excInfo := &ExceptionInfo{Type: excType}
+			excInfo.Module, _ = result["exc_module"].(string)
+			switch excMessage := result["exc_message"].(type) {
+			case []interface{}:
+				excInfo.Message = excMessage
+			case nil:
+			default:
+				excInfo.Message = []interface{}{excMessage}
+			}
+			return excInfo
+		}
+	}
+	return fmt.Errorf("task failed: %v", msg.Result)
+}
+
 func releaseResultMessage(v *ResultMessage) {
 	v.reset()
 	resultMessagePool.Put(v)
@@ -0,0 +1,294 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publishedAtHeader carries publishing time used to measure queue wait time
+const publishedAtHeader = "published_at"
+
+// DefaultDurationBuckets are histogram buckets (in seconds) of wait and execution time
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// QueueInspector reports number of messages waiting in broker queue
+type QueueInspector interface {
+	QueueDepth(ctx context.Context, queue string) (int64, error)
+}
+
+// Metrics collects metrics of clients, workers and brokers
+// and serves them in Prometheus text format.
+// All methods are safe to call on nil Metrics.
+type Metrics struct {
+	lock     sync.Mutex
+	families []*metricFamily
+	watched  []watchedQueue
+
+	published  *metricFamily
+	received   *metricFamily
+	succeeded  *metricFamily
+	failed     *metricFamily
+	retried    *metricFamily
+	abandoned  *metricFamily
+	panicked   *metricFamily
+	queueWait  *metricFamily
+	execution  *metricFamily
+	inFlight   *metricFamily
+	queueDepth *metricFamily
+}
+
+type watchedQueue struct {
+	inspector QueueInspector
+	queue     string
+}
+
+// NewMetrics creates new Metrics
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	taskLabels := []string{"task", "queue"}
+	m.published = m.newFamily("celery_tasks_published_total", "Number of tasks published.", "counter", taskLabels)
+	m.received = m.newFamily("celery_tasks_received_total", "Number of tasks received by workers.", "counter", taskLabels)
+	m.succeeded = m.newFamily("celery_tasks_succeeded_total", "Number of tasks which succeeded.", "counter", taskLabels)
+	m.failed = m.newFamily("celery_tasks_failed_total", "Number of tasks which failed.", "counter", taskLabels)
+	m.retried = m.newFamily("celery_tasks_retried_total", "Number of tasks sent back to broker to be retried.", "counter", taskLabels)
+	m.abandoned = m.newFamily("celery_tasks_abandoned_total", "Number of tasks abandoned after exceeding hard time limit.", "counter", taskLabels)
+	m.panicked = m.newFamily("celery_tasks_panicked_total", "Number of tasks which panicked.", "counter", taskLabels)
+	m.queueWait = m.newFamily("celery_task_queue_wait_seconds", "Time tasks spent in queue before received by worker.", "histogram", taskLabels)
+	m.execution = m.newFamily("celery_task_execution_seconds", "Time spent executing tasks.", "histogram", taskLabels)
+	m.inFlight = m.newFamily("celery_tasks_in_flight", "Number of tasks being executed.", "gauge", taskLabels)
+	m.queueDepth = m.newFamily("celery_queue_depth", "Number of messages waiting in queue.", "gauge", []string{"queue"})
+	return m
+}
+
+func (m *Metrics) newFamily(name, help, typ string, labels []string) *metricFamily {
+	family := &metricFamily{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: map[string]*metricSeries{},
+	}
+	m.families = append(m.families, family)
+	return family
+}
+
+// WatchQueueDepth reports depth of given queues inspected when metrics are served
+func (m *Metrics) WatchQueueDepth(inspector QueueInspector, queues ...string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, queue := range queues {
+		m.watched = append(m.watched, watchedQueue{inspector: inspector, queue: queue})
+	}
+}
+
+// taskPublished counts published task
+func (m *Metrics) taskPublished(message *TaskMessage) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.published.get(message.Task, messageQueue(message)).value++
+	m.lock.Unlock()
+}
+
+// taskReceived counts received task and measures time it waited in queue
+func (m *Metrics) taskReceived(message *TaskMessage) {
+	if m == nil {
+		return
+	}
+	queue := messageQueue(message)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.received.get(message.Task, queue).value++
+	m.inFlight.get(message.Task, queue).value++
+	if publishedAt, ok := message.Headers[publishedAtHeader].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, publishedAt); err == nil {
+			m.queueWait.get(message.Task, queue).observe(time.Since(t).Seconds())
+		}
+	}
+}
+
+// taskProcessed measures execution time and counts task by its final status
+func (m *Metrics) taskProcessed(message *TaskMessage, status string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	queue := messageQueue(message)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.inFlight.get(message.Task, queue).value--
+	m.execution.get(message.Task, queue).observe(duration.Seconds())
+	switch status {
+	case "SUCCESS":
+		m.succeeded.get(message.Task, queue).value++
+	case "RETRY":
+		m.retried.get(message.Task, queue).value++
+	default:
+		m.failed.get(message.Task, queue).value++
+	}
+}
+
+// taskAbandoned counts task abandoned after exceeding hard time limit
+func (m *Metrics) taskAbandoned(message *TaskMessage) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.abandoned.get(message.Task, messageQueue(message)).value++
+	m.lock.Unlock()
+}
+
+// taskPanicked counts panicking task
+func (m *Metrics) taskPanicked(message *TaskMessage) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.panicked.get(message.Task, messageQueue(message)).value++
+	m.lock.Unlock()
+}
+
+// ServeHTTP serves metrics in Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	watched := append([]watchedQueue(nil), m.watched...)
+	m.lock.Unlock()
+
+	// inspect queues outside of the lock as it involves network round trips
+	depths := make([]int64, len(watched))
+	inspected := make([]bool, len(watched))
+	for i, wq := range watched {
+		depth, err := wq.inspector.QueueDepth(r.Context(), wq.queue)
+		depths[i], inspected[i] = depth, err == nil
+	}
+
+	var buf bytes.Buffer
+	m.lock.Lock()
+	for i, wq := range watched {
+		if inspected[i] {
+			m.queueDepth.get(wq.queue).value = float64(depths[i])
+		}
+	}
+	for _, family := range m.families {
+		family.write(&buf)
+	}
+	m.lock.Unlock()
+	w.Write(buf.Bytes())
+}
+
+// Handler returns http.Handler serving metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return m
+}
+
+// messageQueue returns name of the queue task message is routed to
+func messageQueue(message *TaskMessage) string {
+	return message.DeliveryInfo.queueName()
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	sum         float64
+	count       uint64
+}
+
+// get returns series with given label values creating it if necessary
+func (f *metricFamily) get(labelValues ...string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := f.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+		if f.typ == "histogram" {
+			series.buckets = make([]uint64, len(DefaultDurationBuckets))
+		}
+		f.series[key] = series
+	}
+	return series
+}
+
+func (s *metricSeries) observe(value float64) {
+	for i, bound := range DefaultDurationBuckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// write writes metric family in Prometheus text format
+func (f *metricFamily) write(buf *bytes.Buffer) {
+	if len(f.series) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := f.series[key]
+		labels := formatLabels(f.labels, series.labelValues)
+		if f.typ != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, wrapLabels(labels), formatFloat(series.value))
+			continue
+		}
+		for i, bound := range DefaultDurationBuckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, wrapLabels(labels, `le="`+formatFloat(bound)+`"`), series.buckets[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, wrapLabels(labels, `le="+Inf"`), series.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatFloat(series.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, wrapLabels(labels), series.count)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) []string {
+	labels := make([]string, len(names))
+	for i, name := range names {
+		labels[i] = name + `="` + labelValueReplacer.Replace(values[i]) + `"`
+	}
+	return labels
+}
+
+func wrapLabels(labels []string, extra ...string) string {
+	labels = append(append([]string(nil), labels...), extra...)
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
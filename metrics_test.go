@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// staticInspector reports fixed queue depths
+type staticInspector map[string]int64
+
+func (i staticInspector) QueueDepth(ctx context.Context, queue string) (int64, error) {
+	depth, ok := i[queue]
+	if !ok {
+		return 0, fmt.Errorf("queue %s does not exist", queue)
+	}
+	return depth, nil
+}
+
+// TestMetrics tests metrics collected by client and worker in Prometheus text format
+func TestMetrics(t *testing.T) {
+	cli := newLoopbackClient()
+
+	metrics := NewMetrics()
+	metrics.WatchQueueDepth(staticInspector{"celery": 7}, "celery", "missing")
+	cli.SetMetrics(metrics)
+	cli.Register("divide", divide)
+
+	ctx := context.Background()
+	for _, args := range [][]interface{}{{4, 2}, {6, 3}, {1, 0}} {
+		if _, err := cli.Delay(ctx, TIMEOUT, "divide", args...); err != nil {
+			t.Fatalf("failed to send task: %v", err)
+		}
+	}
+	if _, err := cli.DelayKwargs(ctx, TIMEOUT, "divide", nil, `priority"queue`); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	expected := []string{
+		"# TYPE celery_tasks_published_total counter",
+		`celery_tasks_published_total{task="divide",queue="celery"} 3`,
+		`celery_tasks_published_total{task="divide",queue="priority\"queue"} 1`,
+		`celery_tasks_received_total{task="divide",queue="celery"} 3`,
+		`celery_tasks_succeeded_total{task="divide",queue="celery"} 2`,
+		`celery_tasks_failed_total{task="divide",queue="celery"} 1`,
+		`celery_tasks_failed_total{task="divide",queue="priority\"queue"} 1`,
+		`celery_tasks_in_flight{task="divide",queue="celery"} 0`,
+		"# TYPE celery_task_queue_wait_seconds histogram",
+		`celery_task_queue_wait_seconds_count{task="divide",queue="celery"} 3`,
+		`celery_task_execution_seconds_bucket{task="divide",queue="celery",le="+Inf"} 3`,
+		`celery_queue_depth{queue="celery"} 7`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics are missing %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `queue="missing"`) {
+		t.Errorf("metrics should not report depth of queue which failed inspection:\n%s", body)
+	}
+}
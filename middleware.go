@@ -0,0 +1,58 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+)
+
+// PublishHandler publishes task message to broker
+// Headers and DeliveryInfo of the message are sent as message envelope.
+type PublishHandler func(ctx context.Context, message *TaskMessage) error
+
+// PublishMiddleware wraps PublishHandler of CeleryClient
+type PublishMiddleware func(next PublishHandler) PublishHandler
+
+// ExecuteHandler executes task message and returns its result
+type ExecuteHandler func(ctx context.Context, message *TaskMessage) (*ResultMessage, error)
+
+// ExecuteMiddleware wraps ExecuteHandler of CeleryWorker
+type ExecuteMiddleware func(next ExecuteHandler) ExecuteHandler
+
+// chainPublish wraps handler with middlewares, first middleware being outermost
+func chainPublish(handler PublishHandler, middlewares []PublishMiddleware) PublishHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// chainExecute wraps handler with middlewares, first middleware being outermost
+func chainExecute(handler ExecuteHandler, middlewares []ExecuteMiddleware) ExecuteHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// TaskSignalHandler is called on task lifecycle events
+// equivalent to celery before_task_publish and task_prerun signals
+type TaskSignalHandler func(ctx context.Context, message *TaskMessage)
+
+// TaskPostrunHandler is called after task is executed
+// equivalent to celery task_postrun signal
+type TaskPostrunHandler func(ctx context.Context, message *TaskMessage, result *ResultMessage)
+
+// TaskErrorHandler is called when task fails or is retried
+// equivalent to celery task_failure and task_retry signals
+type TaskErrorHandler func(ctx context.Context, message *TaskMessage, err error)
+
+// workerSignals holds handlers of worker signals
+type workerSignals struct {
+	prerun  []TaskSignalHandler
+	postrun []TaskPostrunHandler
+	failure []TaskErrorHandler
+	retry   []TaskErrorHandler
+}
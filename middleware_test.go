@@ -0,0 +1,232 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PerformLine/go-stockutil/stringutil"
+)
+
+// TestMiddlewareChain tests order of publish and execute middlewares and signals
+func TestMiddlewareChain(t *testing.T) {
+	cli := newLoopbackClient()
+
+	var events []string
+	record := func(event string) {
+		events = append(events, event)
+	}
+	cli.UsePublishMiddleware(
+		func(next PublishHandler) PublishHandler {
+			return func(ctx context.Context, message *TaskMessage) error {
+				record("publish outer")
+				message.Headers["tenant"] = "acme"
+				return next(ctx, message)
+			}
+		},
+		func(next PublishHandler) PublishHandler {
+			return func(ctx context.Context, message *TaskMessage) error {
+				record("publish inner")
+				return next(ctx, message)
+			}
+		},
+	)
+	cli.OnBeforeTaskPublish(func(ctx context.Context, message *TaskMessage) {
+		record("before_task_publish")
+	})
+	cli.UseExecuteMiddleware(func(next ExecuteHandler) ExecuteHandler {
+		return func(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
+			record("execute " + fmt.Sprint(message.Headers["tenant"]))
+			resultMsg, err := next(ctx, message)
+			record(fmt.Sprintf("executed %v", resultMsg.Result))
+			return resultMsg, err
+		}
+	})
+	cli.OnTaskPrerun(func(ctx context.Context, message *TaskMessage) {
+		if req, ok := TaskRequestFromContext(ctx); ok && req.ID == message.ID {
+			record("task_prerun")
+		}
+	})
+	cli.OnTaskPostrun(func(ctx context.Context, message *TaskMessage, result *ResultMessage) {
+		record("task_postrun " + result.Status)
+	})
+	cli.OnTaskFailure(func(ctx context.Context, message *TaskMessage, err error) {
+		record("task_failure " + err.Error())
+	})
+
+	taskName := stringutil.UUID().String()
+	cli.Register(taskName, divide)
+	ctx := context.Background()
+	if _, err := cli.Delay(ctx, TIMEOUT, taskName, 6, 3); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	if _, err := cli.Delay(ctx, TIMEOUT, taskName, 6, 0); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	expected := []string{
+		"publish outer",
+		"publish inner",
+		"before_task_publish",
+		"task_prerun",
+		"execute acme",
+		"executed 2",
+		"task_postrun SUCCESS",
+		"publish outer",
+		"publish inner",
+		"before_task_publish",
+		"task_prerun",
+		"execute acme",
+		"executed Exception: division by zero",
+		"task_failure Exception: division by zero",
+		"task_postrun FAILURE",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("events %q are different from expected %q", events, expected)
+	}
+}
+
+// TestWorkerSignalPanic tests that panicking signal handlers do not affect task processing
+func TestWorkerSignalPanic(t *testing.T) {
+	backend := NewMemoryCeleryBackend()
+	celeryWorker := NewCeleryWorker(&captureBroker{}, backend, 1)
+	celeryWorker.SetLogger(NopLogger())
+	taskName := stringutil.UUID().String()
+	celeryWorker.Register(taskName, divide)
+
+	var handled []string
+	celeryWorker.OnTaskPrerun(func(ctx context.Context, message *TaskMessage) {
+		panic("prerun")
+	})
+	celeryWorker.OnTaskPrerun(func(ctx context.Context, message *TaskMessage) {
+		handled = append(handled, "task_prerun")
+	})
+	celeryWorker.OnTaskFailure(func(ctx context.Context, message *TaskMessage, err error) {
+		panic("failure")
+	})
+	celeryWorker.OnTaskPostrun(func(ctx context.Context, message *TaskMessage, result *ResultMessage) {
+		panic("postrun")
+	})
+	celeryWorker.OnTaskPostrun(func(ctx context.Context, message *TaskMessage, result *ResultMessage) {
+		handled = append(handled, "task_postrun")
+	})
+
+	taskMessage := &TaskMessage{
+		ID:   stringutil.UUID().String(),
+		Task: taskName,
+		Args: []interface{}{6, 0},
+	}
+	ctx := context.Background()
+	celeryWorker.processTask(ctx, ctx, taskMessage)
+
+	if expected := []string{"task_prerun", "task_postrun"}; !reflect.DeepEqual(handled, expected) {
+		t.Errorf("expected handlers %v to run, got %v", expected, handled)
+	}
+	resultMsg, err := backend.GetResult(ctx, taskMessage.ID)
+	if err != nil || resultMsg.Status != "FAILURE" {
+		t.Errorf("expected FAILURE result to be stored, got %+v (%v)", resultMsg, err)
+	}
+}
+
+// TestWorkerRetryTask tests retrying task and exhausting its retries
+func TestWorkerRetryTask(t *testing.T) {
+	testCases := []struct {
+		name     string
+		retries  int
+		status   string
+		retried  bool
+		excType  string
+		failures int
+	}{
+		{
+			name:    "task retried",
+			retries: 1,
+			status:  "RETRY",
+			retried: true,
+			excType: "Exception",
+		},
+		{
+			name:     "task exhausted its retries",
+			retries:  3,
+			status:   "FAILURE",
+			excType:  "MaxRetriesExceededError",
+			failures: 1,
+		},
+	}
+	for _, tc := range testCases {
+		broker := &captureBroker{}
+		backend := NewMemoryCeleryBackend()
+		celeryWorker := NewCeleryWorker(broker, backend, 1)
+		taskName := stringutil.UUID().String()
+		celeryWorker.Register(taskName, func() (int, error) {
+			return 0, Retry(fmt.Errorf("temporarily unavailable"), time.Minute, 3)
+		})
+		var retries, failures int
+		celeryWorker.OnTaskRetry(func(ctx context.Context, message *TaskMessage, err error) {
+			retries++
+		})
+		celeryWorker.OnTaskFailure(func(ctx context.Context, message *TaskMessage, err error) {
+			failures++
+		})
+		taskMessage := &TaskMessage{
+			ID:      stringutil.UUID().String(),
+			Task:    taskName,
+			Args:    []interface{}{},
+			Retries: tc.retries,
+		}
+		ctx := context.Background()
+		celeryWorker.processTask(ctx, ctx, taskMessage)
+
+		resultMsg, err := backend.GetResult(ctx, taskMessage.ID)
+		if err != nil {
+			t.Errorf("test '%s': failed to get result: %v", tc.name, err)
+			continue
+		}
+		if resultMsg.Status != tc.status {
+			t.Errorf("test '%s': expected status %s but received %s", tc.name, tc.status, resultMsg.Status)
+		}
+		if excInfo, ok := resultMsg.Result.(map[string]interface{}); !ok || excInfo["exc_type"] != tc.excType {
+			t.Errorf("test '%s': expected %s but received %+v", tc.name, tc.excType, resultMsg.Result)
+		}
+		if failures != tc.failures {
+			t.Errorf("test '%s': expected %d task_failure signals but received %d", tc.name, tc.failures, failures)
+		}
+		if !tc.retried {
+			if len(broker.messages) != 0 || retries != 0 {
+				t.Errorf("test '%s': task should not be retried", tc.name)
+			}
+			continue
+		}
+		if len(broker.messages) != 1 || retries != 1 {
+			t.Errorf("test '%s': expected task to be retried once", tc.name)
+			continue
+		}
+		retryMessage := broker.messages[0]
+		if retryMessage.ID != taskMessage.ID || retryMessage.Retries != tc.retries+1 || retryMessage.ETA == nil {
+			t.Errorf("test '%s': retried message %+v does not match original message %+v", tc.name, retryMessage, taskMessage)
+		}
+	}
+}
+
+// captureBroker keeps sent messages without delivering them
+type captureBroker struct {
+	sync.Mutex
+	messages []*TaskMessage
+}
+
+func (b *captureBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
+	b.Lock()
+	defer b.Unlock()
+	b.messages = append(b.messages, message.GetTaskMessage(ctx, timeout))
+	return nil
+}
+
+func (b *captureBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	return nil, fmt.Errorf("capture broker does not deliver messages")
+}
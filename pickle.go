@@ -0,0 +1,491 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// pickle opcodes of values representable in json
+const (
+	pickleMark           = '('
+	pickleStop           = '.'
+	pickleNone           = 'N'
+	pickleBinInt         = 'J'
+	pickleBinInt1        = 'K'
+	pickleBinInt2        = 'M'
+	pickleBinFloat       = 'G'
+	pickleBinUnicode     = 'X'
+	pickleShortBinString = 'U'
+	pickleBinString      = 'T'
+	pickleShortBinBytes  = 'C'
+	pickleBinBytes       = 'B'
+	pickleEmptyList      = ']'
+	pickleEmptyDict      = '}'
+	pickleEmptyTuple     = ')'
+	pickleAppend         = 'a'
+	pickleAppends        = 'e'
+	pickleList           = 'l'
+	pickleDict           = 'd'
+	pickleSetItem        = 's'
+	pickleSetItems       = 'u'
+	pickleTuple          = 't'
+	pickleBinGet         = 'h'
+	pickleLongBinGet     = 'j'
+	pickleBinPut         = 'q'
+	pickleLongBinPut     = 'r'
+	pickleProto          = 0x80
+	pickleTuple1         = 0x85
+	pickleTuple2         = 0x86
+	pickleTuple3         = 0x87
+	pickleNewTrue        = 0x88
+	pickleNewFalse       = 0x89
+	pickleLong1          = 0x8a
+	pickleLong4          = 0x8b
+	pickleShortUnicode   = 0x8c
+	pickleBinUnicode8    = 0x8d
+	pickleBinBytes8      = 0x8e
+	pickleEmptySet       = 0x8f
+	pickleAddItems       = 0x90
+	pickleFrozenSet      = 0x91
+	pickleMemoize        = 0x94
+	pickleFrame          = 0x95
+)
+
+// encodePickle encodes value as pickle of protocol 2 readable by python
+// Value is converted through json, so it is pickled as corresponding
+// python None, bool, int, float, str, list or dict.
+func encodePickle(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var normalized interface{}
+	if err := decoder.Decode(&normalized); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{pickleProto, 2})
+	if err := writePickle(&buf, normalized); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(pickleStop)
+	return buf.Bytes(), nil
+}
+
+func writePickle(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(pickleNone)
+	case bool:
+		if v {
+			buf.WriteByte(pickleNewTrue)
+		} else {
+			buf.WriteByte(pickleNewFalse)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writePickleInt(buf, i)
+			return nil
+		}
+		if i, ok := new(big.Int).SetString(v.String(), 10); ok {
+			writePickleLong(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(pickleBinFloat)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		buf.WriteByte(pickleBinUnicode)
+		binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		buf.WriteByte(pickleEmptyList)
+		if len(v) == 0 {
+			return nil
+		}
+		buf.WriteByte(pickleMark)
+		for _, item := range v {
+			if err := writePickle(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(pickleAppends)
+	case map[string]interface{}:
+		buf.WriteByte(pickleEmptyDict)
+		if len(v) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte(pickleMark)
+		for _, key := range keys {
+			writePickle(buf, key)
+			if err := writePickle(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(pickleSetItems)
+	default:
+		return fmt.Errorf("cannot pickle %T", value)
+	}
+	return nil
+}
+
+func writePickleInt(buf *bytes.Buffer, i int64) {
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		writePickleLong(buf, big.NewInt(i))
+		return
+	}
+	buf.WriteByte(pickleBinInt)
+	binary.Write(buf, binary.LittleEndian, int32(i))
+}
+
+// writePickleLong writes integer as little endian two's complement
+func writePickleLong(buf *bytes.Buffer, i *big.Int) {
+	n := (i.BitLen() + 8) / 8
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(n*8))
+	be := new(big.Int).Mod(i, mod).FillBytes(make([]byte, n))
+	buf.WriteByte(pickleLong1)
+	buf.WriteByte(byte(n))
+	for j := n - 1; j >= 0; j-- {
+		buf.WriteByte(be[j])
+	}
+}
+
+// pickleListValue is list being built by pickle opcodes
+type pickleListValue struct {
+	items []interface{}
+}
+
+// pickleMarkValue marks start of items on pickle stack
+type pickleMarkValue struct{}
+
+// decodePickle decodes pickle of python None, bool, int, float, str, bytes,
+// list, tuple, set and dict values of any protocol
+// Numbers are decoded as float64 and tuples and sets as lists like by json decoding.
+// Pickles of other python objects are rejected.
+func decodePickle(data []byte) (interface{}, error) {
+	r := &pickleReader{data: data}
+	var stack []interface{}
+	memo := map[uint64]interface{}{}
+	pop := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("pickle stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	popMark := func() ([]interface{}, error) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if _, ok := stack[i].(pickleMarkValue); ok {
+				items := append([]interface{}(nil), stack[i+1:]...)
+				stack = stack[:i]
+				return items, nil
+			}
+		}
+		return nil, fmt.Errorf("pickle mark not found")
+	}
+	top := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("pickle stack underflow")
+		}
+		return stack[len(stack)-1], nil
+	}
+
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case pickleStop:
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			return finishPickleValue(v, map[*pickleListValue][]interface{}{}), nil
+		case pickleProto:
+			_, err = r.byte()
+		case pickleFrame:
+			_, err = r.uint(8)
+		case pickleNone:
+			stack = append(stack, nil)
+		case pickleNewTrue:
+			stack = append(stack, true)
+		case pickleNewFalse:
+			stack = append(stack, false)
+		case pickleBinInt:
+			var n uint64
+			if n, err = r.uint(4); err == nil {
+				stack = append(stack, float64(int32(uint32(n))))
+			}
+		case pickleBinInt1:
+			var n uint64
+			if n, err = r.uint(1); err == nil {
+				stack = append(stack, float64(n))
+			}
+		case pickleBinInt2:
+			var n uint64
+			if n, err = r.uint(2); err == nil {
+				stack = append(stack, float64(n))
+			}
+		case pickleLong1, pickleLong4:
+			var b []byte
+			if b, err = r.sized(map[byte]int{pickleLong1: 1, pickleLong4: 4}[op]); err == nil {
+				stack = append(stack, pickleLongValue(b))
+			}
+		case pickleBinFloat:
+			var b []byte
+			if b, err = r.bytes(8); err == nil {
+				stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(b)))
+			}
+		case pickleShortUnicode, pickleBinUnicode, pickleBinUnicode8, pickleShortBinString, pickleBinString:
+			var b []byte
+			if b, err = r.sized(pickleSizeLength(op)); err == nil {
+				stack = append(stack, string(b))
+			}
+		case pickleShortBinBytes, pickleBinBytes, pickleBinBytes8:
+			var b []byte
+			if b, err = r.sized(pickleSizeLength(op)); err == nil {
+				stack = append(stack, append([]byte(nil), b...))
+			}
+		case pickleMark:
+			stack = append(stack, pickleMarkValue{})
+		case pickleEmptyList, pickleEmptySet:
+			stack = append(stack, &pickleListValue{})
+		case pickleEmptyDict:
+			stack = append(stack, map[string]interface{}{})
+		case pickleEmptyTuple:
+			stack = append(stack, []interface{}{})
+		case pickleTuple1, pickleTuple2, pickleTuple3:
+			n := int(op-pickleTuple1) + 1
+			if len(stack) < n {
+				return nil, fmt.Errorf("pickle stack underflow")
+			}
+			tuple := append([]interface{}(nil), stack[len(stack)-n:]...)
+			stack = append(stack[:len(stack)-n], tuple)
+		case pickleTuple, pickleFrozenSet:
+			var items []interface{}
+			if items, err = popMark(); err == nil {
+				stack = append(stack, items)
+			}
+		case pickleList:
+			var items []interface{}
+			if items, err = popMark(); err == nil {
+				stack = append(stack, &pickleListValue{items: items})
+			}
+		case pickleDict:
+			var items []interface{}
+			if items, err = popMark(); err == nil {
+				dict := map[string]interface{}{}
+				err = setPickleItems(dict, items)
+				stack = append(stack, dict)
+			}
+		case pickleAppend:
+			var item, list interface{}
+			if item, err = pop(); err == nil {
+				if list, err = top(); err == nil {
+					err = appendPickleItems(list, []interface{}{item})
+				}
+			}
+		case pickleAppends, pickleAddItems:
+			var items []interface{}
+			var list interface{}
+			if items, err = popMark(); err == nil {
+				if list, err = top(); err == nil {
+					err = appendPickleItems(list, items)
+				}
+			}
+		case pickleSetItem:
+			var key, value, dict interface{}
+			if value, err = pop(); err == nil {
+				if key, err = pop(); err == nil {
+					if dict, err = top(); err == nil {
+						err = setPickleItems(dict, []interface{}{key, value})
+					}
+				}
+			}
+		case pickleSetItems:
+			var items []interface{}
+			var dict interface{}
+			if items, err = popMark(); err == nil {
+				if dict, err = top(); err == nil {
+					err = setPickleItems(dict, items)
+				}
+			}
+		case pickleMemoize:
+			var v interface{}
+			if v, err = top(); err == nil {
+				memo[uint64(len(memo))] = v
+			}
+		case pickleBinPut, pickleLongBinPut:
+			var idx uint64
+			var v interface{}
+			if idx, err = r.uint(map[byte]int{pickleBinPut: 1, pickleLongBinPut: 4}[op]); err == nil {
+				if v, err = top(); err == nil {
+					memo[idx] = v
+				}
+			}
+		case pickleBinGet, pickleLongBinGet:
+			var idx uint64
+			if idx, err = r.uint(map[byte]int{pickleBinGet: 1, pickleLongBinGet: 4}[op]); err == nil {
+				v, ok := memo[idx]
+				if !ok {
+					return nil, fmt.Errorf("pickle memo %d not found", idx)
+				}
+				stack = append(stack, v)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported pickle opcode 0x%02x", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pickleSizeLength returns length of size prefix of string opcode
+func pickleSizeLength(op byte) int {
+	switch op {
+	case pickleShortUnicode, pickleShortBinString, pickleShortBinBytes:
+		return 1
+	case pickleBinUnicode8, pickleBinBytes8:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// pickleLongValue decodes little endian two's complement integer
+func pickleLongValue(b []byte) float64 {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	i := new(big.Int).SetBytes(be)
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	f, _ := new(big.Float).SetInt(i).Float64()
+	return f
+}
+
+func appendPickleItems(list interface{}, items []interface{}) error {
+	l, ok := list.(*pickleListValue)
+	if !ok {
+		return fmt.Errorf("cannot append to pickled %T", list)
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+func setPickleItems(dict interface{}, items []interface{}) error {
+	d, ok := dict.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("cannot set items of pickled %T", dict)
+	}
+	if len(items)%2 != 0 {
+		return fmt.Errorf("odd number of pickled dict items")
+	}
+	for i := 0; i < len(items); i += 2 {
+		key, ok := items[i].(string)
+		if !ok {
+			key = fmt.Sprint(items[i])
+		}
+		d[key] = items[i+1]
+	}
+	return nil
+}
+
+// finishPickleValue replaces lists built while decoding with slices
+func finishPickleValue(v interface{}, lists map[*pickleListValue][]interface{}) interface{} {
+	switch value := v.(type) {
+	case *pickleListValue:
+		if list, ok := lists[value]; ok {
+			return list
+		}
+		list := make([]interface{}, len(value.items))
+		lists[value] = list
+		for i, item := range value.items {
+			list[i] = finishPickleValue(item, lists)
+		}
+		return list
+	case []interface{}:
+		for i, item := range value {
+			value[i] = finishPickleValue(item, lists)
+		}
+		return value
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = finishPickleValue(item, lists)
+		}
+		return value
+	}
+	return v
+}
+
+// pickleReader reads opcode arguments of pickle
+type pickleReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *pickleReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("unexpected end of pickle")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *pickleReader) byte() (byte, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// uint reads little endian unsigned integer of n bytes
+func (r *pickleReader) uint(n int) (uint64, error) {
+	b, err := r.bytes(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, nil
+}
+
+// sized reads bytes prefixed by their little endian length of n bytes
+func (r *pickleReader) sized(n int) ([]byte, error) {
+	size, err := r.uint(n)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(len(r.data)) {
+		return nil, fmt.Errorf("unexpected end of pickle")
+	}
+	return r.bytes(int(size))
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// TestDecodePickle tests decoding pickles written by python pickle.dumps with highest protocol
+func TestDecodePickle(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pickle   string
+		expected interface{}
+	}{
+		{name: "None", pickle: "80054e2e", expected: nil},
+		{name: "True", pickle: "8005882e", expected: true},
+		{name: "small int", pickle: "80054b032e", expected: 3.0},
+		{name: "negative int", pickle: "80059506000000000000004afeffffff2e", expected: -2.0},
+		{name: "two byte int", pickle: "80059504000000000000004d2c012e", expected: 300.0},
+		{name: "long", pickle: "80059509000000000000008a060000000000012e", expected: 1099511627776.0},
+		{name: "float", pickle: "8005950a00000000000000473ff80000000000002e", expected: 1.5},
+		{name: "str", pickle: "8005950a000000000000008c0668c3a96c6c6f942e", expected: "héllo"},
+		{name: "bytes", pickle: "800595060000000000000043026162942e", expected: []byte("ab")},
+		{name: "list", pickle: "8005950b000000000000005d94284b018c016194652e", expected: []interface{}{1.0, "a"}},
+		{name: "tuple", pickle: "8005950c00000000000000284b014b024b034b0474942e", expected: []interface{}{1.0, 2.0, 3.0, 4.0}},
+		{
+			name:   "exception dict",
+			pickle: "8005954b000000000000007d94288c086578635f74797065948c0a56616c75654572726f72948c0b6578635f6d657373616765948c036261649485948c0a6578635f6d6f64756c65948c086275696c74696e7394752e",
+			expected: map[string]interface{}{
+				"exc_type":    "ValueError",
+				"exc_message": []interface{}{"bad"},
+				"exc_module":  "builtins",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		data, _ := hex.DecodeString(tc.pickle)
+		value, err := decodePickle(data)
+		if err != nil {
+			t.Errorf("test '%s': failed to decode pickle: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, tc.expected) {
+			t.Errorf("test '%s': expected %#v, got %#v", tc.name, tc.expected, value)
+		}
+	}
+
+	// pickle of datetime.date(2020, 1, 1) refers to python class
+	data, _ := hex.DecodeString("80059520000000000000008c086461746574696d65948c0464617465949394430407e4010194859452942e")
+	if _, err := decodePickle(data); err == nil {
+		t.Errorf("pickle of python object should be rejected")
+	}
+}
+
+// TestEncodePickle tests pickling values as python protocol 2 pickles
+// Expected pickles are verified to be loaded by python pickle.loads.
+func TestEncodePickle(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    interface{}
+		pickle   string
+		expected interface{}
+	}{
+		{name: "None", value: nil, pickle: "80024e2e", expected: nil},
+		{name: "int", value: 3, pickle: "80024a030000002e", expected: 3.0},
+		{name: "long", value: int64(1) << 40, pickle: "80028a060000000000012e", expected: 1099511627776.0},
+		{name: "negative long", value: int64(-1) << 40, pickle: "80028a060000000000ff2e", expected: -1099511627776.0},
+		{name: "float", value: 1.5, pickle: "8002473ff80000000000002e", expected: 1.5},
+		{name: "str", value: "héllo", pickle: "8002580600000068c3a96c6c6f2e", expected: "héllo"},
+		{
+			name:     "exception",
+			value:    &ExceptionInfo{Type: "ValueError", Message: []interface{}{"bad"}, Module: "builtins"},
+			pickle:   "80027d28580b0000006578635f6d6573736167655d28580300000062616465580a0000006578635f6d6f64756c6558080000006275696c74696e7358080000006578635f74797065580a00000056616c75654572726f72752e",
+			expected: map[string]interface{}{"exc_type": "ValueError", "exc_message": []interface{}{"bad"}, "exc_module": "builtins"},
+		},
+	}
+	for _, tc := range testCases {
+		data, err := encodePickle(tc.value)
+		if err != nil {
+			t.Errorf("test '%s': failed to encode pickle: %v", tc.name, err)
+			continue
+		}
+		if hex.EncodeToString(data) != tc.pickle {
+			t.Errorf("test '%s': expected pickle %s, got %s", tc.name, tc.pickle, hex.EncodeToString(data))
+		}
+		value, err := decodePickle(data)
+		if err != nil {
+			t.Errorf("test '%s': failed to decode pickle: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, tc.expected) {
+			t.Errorf("test '%s': expected %#v, got %#v", tc.name, tc.expected, value)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Propagator carries values of context across task hops
+// using headers of celery message
+type Propagator interface {
+	// Inject writes values of caller's context into message headers
+	Inject(ctx context.Context, headers map[string]interface{})
+	// Extract returns copy of context carrying values read from message headers
+	Extract(ctx context.Context, headers map[string]interface{}) context.Context
+}
+
+// CompositePropagator runs multiple propagators in order
+type CompositePropagator []Propagator
+
+// Inject writes headers of all propagators
+func (p CompositePropagator) Inject(ctx context.Context, headers map[string]interface{}) {
+	for _, propagator := range p {
+		propagator.Inject(ctx, headers)
+	}
+}
+
+// Extract reads headers of all propagators
+func (p CompositePropagator) Extract(ctx context.Context, headers map[string]interface{}) context.Context {
+	for _, propagator := range p {
+		ctx = propagator.Extract(ctx, headers)
+	}
+	return ctx
+}
+
+// ValuePropagator propagates string context value stored under Key in Header
+// such as request or correlation ID
+type ValuePropagator struct {
+	Header string
+	Key    interface{}
+}
+
+// Inject writes context value into header
+func (p ValuePropagator) Inject(ctx context.Context, headers map[string]interface{}) {
+	if val, ok := ctx.Value(p.Key).(string); ok && val != "" {
+		headers[p.Header] = val
+	}
+}
+
+// Extract stores header value in context
+func (p ValuePropagator) Extract(ctx context.Context, headers map[string]interface{}) context.Context {
+	if val, ok := headers[p.Header].(string); ok && val != "" {
+		return context.WithValue(ctx, p.Key, val)
+	}
+	return ctx
+}
+
+// SpanContext identifies span of a trace as defined by W3C Trace Context
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Flags      byte
+	TraceState string
+	Remote     bool
+}
+
+// IsValid reports whether span context has non-zero trace and span IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// IsSampled reports whether sampled flag is set
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&0x01 == 0x01
+}
+
+// TraceParent returns W3C traceparent header value
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// ParseTraceParent parses W3C traceparent header value
+func ParseTraceParent(traceParent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("malformed traceparent %q", traceParent)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("malformed traceparent %q", traceParent)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("malformed trace ID in traceparent %q", traceParent)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("malformed span ID in traceparent %q", traceParent)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("malformed flags in traceparent %q", traceParent)
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", traceParent)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns copy of parent context carrying given span context
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns span context carried by context
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// TraceContextPropagator propagates span context using W3C traceparent and tracestate headers
+type TraceContextPropagator struct{}
+
+// Inject writes traceparent and tracestate headers
+func (TraceContextPropagator) Inject(ctx context.Context, headers map[string]interface{}) {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	headers["traceparent"] = sc.TraceParent()
+	if sc.TraceState != "" {
+		headers["tracestate"] = sc.TraceState
+	}
+}
+
+// Extract reads remote span context from traceparent and tracestate headers
+func (TraceContextPropagator) Extract(ctx context.Context, headers map[string]interface{}) context.Context {
+	traceParent, ok := headers["traceparent"].(string)
+	if !ok {
+		return ctx
+	}
+	sc, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return ctx
+	}
+	if traceState, ok := headers["tracestate"].(string); ok {
+		sc.TraceState = traceState
+	}
+	sc.Remote = true
+	return ContextWithSpanContext(ctx, sc)
+}
@@ -14,14 +14,22 @@ import (
 )
 
 // RedisCeleryBackend is celery backend for redis
+// It works with single server, sentinel and cluster clients.
+// Each result is stored under its own key, therefore no hash tag is required.
 type RedisCeleryBackend struct {
-	*redis.Client
+	redis.UniversalClient
 }
 
 // NewRedisCeleryBackend creates new RedisCeleryBackend
+// See NewRedisUniversalClient for supported URLs.
 func NewRedisCeleryBackend(uri string) *RedisCeleryBackend {
+	return NewRedisCeleryBackendByClient(NewRedisUniversalClient(uri))
+}
+
+// NewRedisCeleryBackendByClient creates new RedisCeleryBackend using given redis client
+func NewRedisCeleryBackendByClient(client redis.UniversalClient) *RedisCeleryBackend {
 	return &RedisCeleryBackend{
-		Client: NewRedisClient(uri),
+		UniversalClient: client,
 	}
 }
 
@@ -7,67 +7,120 @@ package gocelery
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 // RedisCeleryBroker is celery broker for redis
+// It works with single server, sentinel and cluster clients.
+// Messages are popped from queue without tracking of unacknowledged messages,
+// message delivered to worker which stops before finishing it is lost.
+// Each command touches single queue key, therefore no hash tag is required
+// and queues stay compatible with celery. Keys touched together by dead letter
+// queue are hash tagged, see RedisDeadLetterQueue.
 type RedisCeleryBroker struct {
-	*redis.Client
-	queueName string
+	redis.UniversalClient
+	queueName     string
+	settingsLock  sync.RWMutex
+	prefetchCount int
+	logger        Logger
+	deadLetters   DeadLetterQueue
 }
 
-// NewRedisClient creates a redis connection from given connection string
-func NewRedisClient(uri string) *redis.Client {
-	opts, err := redis.ParseURL(uri)
-	if err != nil {
-		panic(err)
-	}
-
-	opts.ConnMaxIdleTime = 240 * time.Second
-	opts.MaxIdleConns = 3
-	opts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
-		return cn.Ping(ctx).Err()
-	}
-
-	return redis.NewClient(opts)
+// NewRedisCeleryBroker creates new RedisCeleryBroker based on given uri
+// See NewRedisUniversalClient for supported URLs.
+func NewRedisCeleryBroker(uri string, queue ...string) *RedisCeleryBroker {
+	return NewRedisCeleryBrokerByClient(NewRedisUniversalClient(uri), queue...)
 }
 
-// NewRedisCeleryBroker creates new RedisCeleryBroker based on given uri
-func NewRedisCeleryBroker(uri string) *RedisCeleryBroker {
+// NewRedisCeleryBrokerByClient creates new RedisCeleryBroker using given redis client
+// Broker consumes given queue, "celery" by default.
+func NewRedisCeleryBrokerByClient(client redis.UniversalClient, queue ...string) *RedisCeleryBroker {
+	queueName := "celery"
+	if len(queue) > 0 && queue[0] != `` {
+		queueName = queue[0]
+	}
 	return &RedisCeleryBroker{
-		Client:    NewRedisClient(uri),
-		queueName: "celery",
+		UniversalClient: client,
+		queueName:       queueName,
+		prefetchCount:   1,
 	}
 }
 
-// SendCeleryMessage sends CeleryMessage to redis queue
+// SendCeleryMessage sends CeleryMessage to redis queue of its delivery info
 func (cb *RedisCeleryBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
 	jsonBytes, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
-	return cb.LPush(ctx, cb.queueName, jsonBytes).Err()
+	return cb.LPush(ctx, message.Properties.DeliveryInfo.queueName(), jsonBytes).Err()
 }
 
 // GetCeleryMessage retrieves celery message from redis queue
 func (cb *RedisCeleryBroker) GetCeleryMessage(ctx context.Context, timeout time.Duration) (*CeleryMessage, error) {
-	messageList, err := cb.BLPop(ctx, timeout, cb.queueName, "1").Result()
+	messageList, err := cb.BLPop(ctx, timeout, cb.queueName).Result()
 	if err != nil {
 		return nil, err
 	}
 	if messageList == nil {
 		return nil, fmt.Errorf("null message received from redis")
 	}
-	if string(messageList[0]) != "celery" {
+	if messageList[0] != cb.queueName {
 		return nil, fmt.Errorf("not a celery message: %v", messageList[0])
 	}
+	message, err := decodeCeleryMessage(messageList[1], cb.queueName)
+	if err != nil {
+		cb.deadLetter(ctx, []byte(messageList[1]), err)
+		return nil, err
+	}
+	return message, nil
+}
+
+// getCeleryMessages retrieves up to count celery messages from redis queue
+// It blocks up to timeout for first message and pops the rest in single pipeline.
+func (cb *RedisCeleryBroker) getCeleryMessages(ctx context.Context, timeout time.Duration, count int) ([]*CeleryMessage, error) {
+	message, err := cb.GetCeleryMessage(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*CeleryMessage{message}
+	if count <= 1 {
+		return messages, nil
+	}
+	pipe := cb.Pipeline()
+	cmds := make([]*redis.StringCmd, count-1)
+	for i := range cmds {
+		cmds[i] = pipe.LPop(ctx, cb.queueName)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return messages, err
+	}
+	for _, cmd := range cmds {
+		payload, err := cmd.Result()
+		if err != nil {
+			continue
+		}
+		message, err := decodeCeleryMessage(payload, cb.queueName)
+		if err != nil {
+			cb.deadLetter(ctx, []byte(payload), err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// decodeCeleryMessage decodes celery message stored in given redis queue
+func decodeCeleryMessage(payload string, queue string) (*CeleryMessage, error) {
 	var message CeleryMessage
-	if err := json.Unmarshal([]byte(messageList[1]), &message); err != nil {
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
 		return nil, err
 	}
+	message.Properties.DeliveryInfo.Queue = queue
 	return &message, nil
 }
 
@@ -77,5 +130,126 @@ func (cb *RedisCeleryBroker) GetTaskMessage(ctx context.Context, timeout time.Du
 	if err != nil {
 		return nil, err
 	}
-	return celeryMessage.GetTaskMessage(ctx, timeout), nil
+	taskMessage, err := celeryMessage.decodeTaskMessage()
+	if err != nil {
+		cb.deadLetterCeleryMessage(ctx, celeryMessage, err)
+		return nil, nil
+	}
+	return taskMessage, nil
+}
+
+// ConsumeTaskMessages streams task messages from redis queue
+// Messages are popped in batches of prefetch count, each batch blocking up to timeout.
+// Messages pending when ctx is done are pushed back to queue.
+func (cb *RedisCeleryBroker) ConsumeTaskMessages(ctx context.Context, timeout time.Duration) (<-chan *TaskMessage, error) {
+	taskMessages := make(chan *TaskMessage)
+	go func() {
+		defer close(taskMessages)
+		logger := cb.getLogger()
+		for ctx.Err() == nil {
+			celeryMessages, err := cb.getCeleryMessages(ctx, timeout, cb.getPrefetchCount())
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("failed to receive message", "queue", cb.queueName, "error", err)
+					sleepContext(ctx, defaultPollInterval)
+				}
+				if len(celeryMessages) == 0 {
+					continue
+				}
+			}
+			for i, celeryMessage := range celeryMessages {
+				taskMessage, err := celeryMessage.decodeTaskMessage()
+				if err != nil {
+					cb.deadLetterCeleryMessage(ctx, celeryMessage, err)
+					continue
+				}
+				if ctx.Err() == nil {
+					select {
+					case taskMessages <- taskMessage:
+						continue
+					case <-ctx.Done():
+					}
+				}
+				cb.requeueCeleryMessages(context.WithoutCancel(ctx), timeout, celeryMessages[i:])
+				break
+			}
+		}
+	}()
+	return taskMessages, nil
+}
+
+// requeueCeleryMessages pushes messages back to queue so that first message is popped first
+func (cb *RedisCeleryBroker) requeueCeleryMessages(ctx context.Context, timeout time.Duration, messages []*CeleryMessage) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if err := cb.SendCeleryMessage(ctx, timeout, messages[i]); err != nil {
+			cb.getLogger().Error("failed to requeue message", "queue", cb.queueName, "error", err)
+		}
+	}
+}
+
+// SetPrefetchCount sets number of messages popped from queue at once by ConsumeTaskMessages
+func (cb *RedisCeleryBroker) SetPrefetchCount(count int) error {
+	if count < 1 {
+		return fmt.Errorf("invalid prefetch count %d", count)
+	}
+	cb.settingsLock.Lock()
+	cb.prefetchCount = count
+	cb.settingsLock.Unlock()
+	return nil
+}
+
+// getPrefetchCount returns number of messages popped from queue at once
+func (cb *RedisCeleryBroker) getPrefetchCount() int {
+	cb.settingsLock.RLock()
+	defer cb.settingsLock.RUnlock()
+	return cb.prefetchCount
+}
+
+// SetDeadLetterQueue sets queue receiving messages which cannot be decoded
+func (cb *RedisCeleryBroker) SetDeadLetterQueue(dlq DeadLetterQueue) {
+	cb.settingsLock.Lock()
+	cb.deadLetters = dlq
+	cb.settingsLock.Unlock()
+}
+
+// getDeadLetterQueue returns queue receiving messages which cannot be decoded
+func (cb *RedisCeleryBroker) getDeadLetterQueue() DeadLetterQueue {
+	cb.settingsLock.RLock()
+	defer cb.settingsLock.RUnlock()
+	return cb.deadLetters
+}
+
+// deadLetter reports undecodable message and stores it in dead letter queue
+func (cb *RedisCeleryBroker) deadLetter(ctx context.Context, body []byte, err error) {
+	logger := cb.getLogger().With("queue", cb.queueName)
+	logger.Error("failed to decode message", "error", err)
+	putDeadLetter(ctx, cb.getDeadLetterQueue(), logger, newDeadLetter(DeadLetterDecodeError, err, cb.queueName, body))
+}
+
+// deadLetterCeleryMessage dead-letters celery message carrying undecodable task message
+func (cb *RedisCeleryBroker) deadLetterCeleryMessage(ctx context.Context, message *CeleryMessage, err error) {
+	body, _ := json.Marshal(message)
+	cb.deadLetter(ctx, body, err)
+}
+
+// SetLogger sets logger used to report undecodable messages
+func (cb *RedisCeleryBroker) SetLogger(logger Logger) {
+	cb.settingsLock.Lock()
+	cb.logger = logger
+	cb.settingsLock.Unlock()
+}
+
+// getLogger returns logger of the broker
+func (cb *RedisCeleryBroker) getLogger() Logger {
+	cb.settingsLock.RLock()
+	defer cb.settingsLock.RUnlock()
+	return loggerOrDefault(cb.logger)
+}
+
+// QueueDepth returns number of messages waiting in given queue
+func (cb *RedisCeleryBroker) QueueDepth(ctx context.Context, queue string) (int64, error) {
+	return cb.LLen(ctx, queue).Result()
 }
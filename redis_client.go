@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient creates a redis connection from given connection string
+func NewRedisClient(uri string) *redis.Client {
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		panic(err)
+	}
+
+	opts.ConnMaxIdleTime = 240 * time.Second
+	opts.MaxIdleConns = 3
+	opts.OnConnect = pingOnConnect
+
+	return redis.NewClient(opts)
+}
+
+// NewRedisUniversalClient creates redis client from given connection string
+// Besides redis:// and rediss:// URLs of single server it accepts
+//
+//	sentinel://[:password@]host:port[/db][;sentinel://host:port...]?master_name=mymaster
+//
+// as accepted by kombu, connecting to master monitored by listed sentinels, and
+//
+//	redis+cluster://[user:password@]host:port[?addr=host:port...]
+//
+// connecting to redis cluster seeded by listed nodes. Password of sentinel
+// URL authenticates to master, sentinel_password query parameter to sentinels.
+func NewRedisUniversalClient(uri string) redis.UniversalClient {
+	switch {
+	case strings.HasPrefix(uri, "sentinel://"):
+		opts, err := parseSentinelURL(uri)
+		if err != nil {
+			panic(err)
+		}
+		opts.ConnMaxIdleTime = 240 * time.Second
+		opts.MaxIdleConns = 3
+		opts.OnConnect = pingOnConnect
+		return redis.NewFailoverClient(opts)
+	case strings.HasPrefix(uri, "redis+cluster://"), strings.HasPrefix(uri, "rediss+cluster://"):
+		opts, err := redis.ParseClusterURL(strings.Replace(uri, "+cluster://", "://", 1))
+		if err != nil {
+			panic(err)
+		}
+		opts.ConnMaxIdleTime = 240 * time.Second
+		opts.MaxIdleConns = 3
+		opts.OnConnect = pingOnConnect
+		return redis.NewClusterClient(opts)
+	default:
+		return NewRedisClient(uri)
+	}
+}
+
+func pingOnConnect(ctx context.Context, cn *redis.Conn) error {
+	return cn.Ping(ctx).Err()
+}
+
+// parseSentinelURL parses semicolon separated sentinel URLs
+// Credentials and database are taken from first URL, query parameters from any of them.
+func parseSentinelURL(uri string) (*redis.FailoverOptions, error) {
+	opts := &redis.FailoverOptions{}
+	for i, part := range strings.Split(uri, ";") {
+		u, err := url.Parse(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "sentinel" {
+			return nil, fmt.Errorf("invalid sentinel URL scheme: %s", u.Scheme)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "26379"
+		}
+		opts.SentinelAddrs = append(opts.SentinelAddrs, net.JoinHostPort(u.Hostname(), port))
+		if i == 0 {
+			if u.User != nil {
+				opts.Username = u.User.Username()
+				opts.Password, _ = u.User.Password()
+			}
+			if db := strings.Trim(u.Path, "/"); db != "" {
+				if opts.DB, err = strconv.Atoi(db); err != nil {
+					return nil, fmt.Errorf("invalid database number %q: %w", db, err)
+				}
+			}
+		}
+		query := u.Query()
+		if name := query.Get("master_name"); name != "" {
+			opts.MasterName = name
+		}
+		if password := query.Get("sentinel_password"); password != "" {
+			opts.SentinelPassword = password
+		}
+	}
+	if opts.MasterName == "" {
+		return nil, fmt.Errorf("sentinel URL requires master_name")
+	}
+	return opts, nil
+}
+
+// redisSlotKey returns key suffixed with given suffix stored in the same cluster slot as key
+// Key without hash tag is wrapped in one, as slot of such key is computed from whole key.
+// Key containing "}" outside of hash tag cannot be wrapped and must carry hash tag itself.
+func redisSlotKey(key, suffix string) string {
+	if start := strings.Index(key, "{"); start >= 0 {
+		if end := strings.Index(key[start+1:], "}"); end > 0 {
+			return key + suffix
+		}
+	}
+	return "{" + key + "}" + suffix
+}
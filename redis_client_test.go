@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TestNewRedisUniversalClient tests client type created for each URL scheme
+func TestNewRedisUniversalClient(t *testing.T) {
+	testCases := []struct {
+		name     string
+		uri      string
+		expected interface{}
+	}{
+		{
+			name:     "single server",
+			uri:      "redis://localhost:6379/1",
+			expected: &redis.Client{},
+		},
+		{
+			name:     "sentinel",
+			uri:      "sentinel://localhost:26379;sentinel://localhost:26380?master_name=mymaster",
+			expected: &redis.Client{},
+		},
+		{
+			name:     "cluster",
+			uri:      "redis+cluster://localhost:7000?addr=localhost:7001",
+			expected: &redis.ClusterClient{},
+		},
+	}
+	for _, tc := range testCases {
+		client := NewRedisUniversalClient(tc.uri)
+		if reflect.TypeOf(client) != reflect.TypeOf(tc.expected) {
+			t.Errorf("test '%s': expected %T, got %T", tc.name, tc.expected, client)
+		}
+		client.Close()
+	}
+}
+
+// TestParseSentinelURL tests parsing of kombu sentinel URLs
+func TestParseSentinelURL(t *testing.T) {
+	opts, err := parseSentinelURL("sentinel://:secret@host1:26379/2;sentinel://host2;sentinel://host3:26381?master_name=mymaster&sentinel_password=sentinels")
+	if err != nil {
+		t.Fatalf("failed to parse sentinel URL: %v", err)
+	}
+	expectedAddrs := []string{"host1:26379", "host2:26379", "host3:26381"}
+	if !reflect.DeepEqual(opts.SentinelAddrs, expectedAddrs) {
+		t.Errorf("expected sentinel addresses %v, got %v", expectedAddrs, opts.SentinelAddrs)
+	}
+	if opts.MasterName != "mymaster" || opts.Password != "secret" || opts.SentinelPassword != "sentinels" || opts.DB != 2 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	for _, uri := range []string{
+		"sentinel://host1:26379",
+		"sentinel://host1:26379;redis://host2:6379?master_name=mymaster",
+		"sentinel://host1:26379/db?master_name=mymaster",
+	} {
+		if _, err := parseSentinelURL(uri); err == nil {
+			t.Errorf("invalid sentinel URL %s should not be parsed", uri)
+		}
+	}
+}
+
+// TestRedisSlotKey tests that derived keys share cluster slot with their key
+func TestRedisSlotKey(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{key: "celery.dlq", expected: "{celery.dlq}.messages"},
+		{key: "{celery}.dlq", expected: "{celery}.dlq.messages"},
+	}
+	for _, tc := range testCases {
+		if key := redisSlotKey(tc.key, ".messages"); key != tc.expected {
+			t.Errorf("test '%s': expected %s, got %s", tc.key, tc.expected, key)
+		}
+	}
+}
+
+// redisHashSlot computes cluster slot of key the way redis cluster does
+func redisHashSlot(key string) uint16 {
+	if start := strings.Index(key, "{"); start >= 0 {
+		if end := strings.Index(key[start+1:], "}"); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+// TestRedisSlotKeySharesSlot tests that keys touched together by dead letter queue share cluster slot
+func TestRedisSlotKeySharesSlot(t *testing.T) {
+	// slots documented by redis cluster specification
+	if slot := redisHashSlot("foo"); slot != 12182 {
+		t.Fatalf("expected slot 12182 of foo, got %d", slot)
+	}
+	if slot := redisHashSlot("{user1000}.following"); slot != redisHashSlot("user1000") {
+		t.Fatalf("expected hash tag to determine slot, got %d", slot)
+	}
+	for _, key := range []string{"celery", "celery.dlq", "{celery}.dlq", "dlq{celery}", "{dlq"} {
+		queue := NewRedisDeadLetterQueue(nil, key)
+		if keySlot, messagesSlot := redisHashSlot(queue.key), redisHashSlot(queue.messagesKey()); keySlot != messagesSlot {
+			t.Errorf("test '%s': key %s in slot %d, messages key %s in slot %d", key, queue.key, keySlot, queue.messagesKey(), messagesSlot)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+)
+
+// TaskRequest represents metadata of task being executed
+type TaskRequest struct {
+	ID           string
+	Task         string
+	Retries      int
+	ETA          *string
+	ParentID     string
+	RootID       string
+	Headers      map[string]interface{}
+	DeliveryInfo CeleryDeliveryInfo
+}
+
+type taskRequestKey struct{}
+
+// newTaskRequest creates TaskRequest from received task message
+// parent and root IDs are taken from celery protocol headers if available
+func newTaskRequest(message *TaskMessage) *TaskRequest {
+	req := &TaskRequest{
+		ID:      message.ID,
+		Task:    message.Task,
+		Retries: message.Retries,
+		ETA:     message.ETA,
+		Headers: message.Headers,
+	}
+	if message.DeliveryInfo != nil {
+		req.DeliveryInfo = *message.DeliveryInfo
+	}
+	if parentID, ok := message.Headers["parent_id"].(string); ok {
+		req.ParentID = parentID
+	}
+	if rootID, ok := message.Headers["root_id"].(string); ok {
+		req.RootID = rootID
+	}
+	return req
+}
+
+// ContextWithTaskRequest returns copy of parent context carrying given task request
+func ContextWithTaskRequest(ctx context.Context, req *TaskRequest) context.Context {
+	return context.WithValue(ctx, taskRequestKey{}, req)
+}
+
+// TaskRequestFromContext returns request of task executed with given context
+func TaskRequestFromContext(ctx context.Context) (*TaskRequest, bool) {
+	req, ok := ctx.Value(taskRequestKey{}).(*TaskRequest)
+	return req, ok
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetryError is returned by task to request its retry
+// Task is sent back to broker with incremented retries and ETA after countdown,
+// worker receiving it holds it until ETA.
+type RetryError struct {
+	Err        error
+	Countdown  time.Duration
+	MaxRetries int // zero allows unlimited retries
+}
+
+// Retry returns error requesting retry of the task after countdown
+// unless the task has been already retried maxRetries times
+func Retry(err error, countdown time.Duration, maxRetries int) error {
+	return &RetryError{
+		Err:        err,
+		Countdown:  countdown,
+		MaxRetries: maxRetries,
+	}
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("retry in %v: %v", e.Countdown, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// retryTaskMessage returns copy of task message scheduled for retry
+func retryTaskMessage(message *TaskMessage, countdown time.Duration) *TaskMessage {
+	retryMessage := *message
+	retryMessage.Retries++
+	eta := time.Now().Add(countdown).UTC().Format(time.RFC3339Nano)
+	retryMessage.ETA = &eta
+	return &retryMessage
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"path"
+	"regexp"
+)
+
+// Route describes where task message is published
+// Empty routing key defaults to queue name and empty exchange
+// publishes directly to queue named by routing key.
+// Brokers without exchanges, such as redis, push message to the queue.
+type Route struct {
+	Queue      string
+	Exchange   string
+	RoutingKey string
+	Priority   int
+}
+
+// deliveryInfo returns delivery info of messages following the route
+func (r *Route) deliveryInfo() *CeleryDeliveryInfo {
+	routingKey := r.RoutingKey
+	if routingKey == "" {
+		routingKey = r.Queue
+	}
+	if routingKey == "" {
+		routingKey = "celery"
+	}
+	return &CeleryDeliveryInfo{
+		Priority:   r.Priority,
+		RoutingKey: routingKey,
+		Exchange:   r.Exchange,
+		Queue:      r.Queue,
+	}
+}
+
+// Router selects route of task message similar to celery task_routes
+// Nil route means router does not apply to the task.
+type Router interface {
+	RouteTask(task *TaskMessage) *Route
+}
+
+// RouterFunc is function used as Router
+type RouterFunc func(task *TaskMessage) *Route
+
+// RouteTask calls f(task)
+func (f RouterFunc) RouteTask(task *TaskMessage) *Route {
+	return f(task)
+}
+
+// RouteGlob routes tasks with names matching glob pattern such as "feeds.*"
+func RouteGlob(pattern string, route Route) Router {
+	return RouterFunc(func(task *TaskMessage) *Route {
+		if matched, _ := path.Match(pattern, task.Task); matched {
+			return &route
+		}
+		return nil
+	})
+}
+
+// RouteRegexp routes tasks with names matching regular expression
+func RouteRegexp(re *regexp.Regexp, route Route) Router {
+	return RouterFunc(func(task *TaskMessage) *Route {
+		if re.MatchString(task.Task) {
+			return &route
+		}
+		return nil
+	})
+}
+
+// routeTask returns route of first router which applies to the task
+func routeTask(routers []Router, task *TaskMessage) *Route {
+	for _, router := range routers {
+		if route := router.RouteTask(task); route != nil {
+			return route
+		}
+	}
+	return nil
+}
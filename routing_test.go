@@ -0,0 +1,131 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestRoutes tests routing of published tasks by name and custom function
+func TestRoutes(t *testing.T) {
+	broker := &captureBroker{}
+	cli, _ := NewCeleryClient(broker, NewMemoryCeleryBackend(), 1)
+	cli.SetRoutes(
+		RouteGlob("feeds.*", Route{Queue: "feeds"}),
+		RouteRegexp(regexp.MustCompile(`^video\.(encode|decode)$`), Route{Queue: "video", Exchange: "media", RoutingKey: "media.video", Priority: 5}),
+		RouterFunc(func(task *TaskMessage) *Route {
+			if strings.HasPrefix(task.Task, "urgent.") {
+				return &Route{Queue: "urgent", Priority: 9}
+			}
+			return nil
+		}),
+	)
+
+	testCases := []struct {
+		name     string
+		task     string
+		queue    string
+		expected CeleryDeliveryInfo
+	}{
+		{
+			name:     "glob",
+			task:     "feeds.import",
+			expected: CeleryDeliveryInfo{RoutingKey: "feeds", Queue: "feeds"},
+		},
+		{
+			name:     "regexp",
+			task:     "video.encode",
+			expected: CeleryDeliveryInfo{RoutingKey: "media.video", Exchange: "media", Priority: 5, Queue: "video"},
+		},
+		{
+			name:     "function",
+			task:     "urgent.page",
+			expected: CeleryDeliveryInfo{RoutingKey: "urgent", Priority: 9, Queue: "urgent"},
+		},
+		{
+			name:     "explicit queue",
+			task:     "feeds.import",
+			queue:    "manual",
+			expected: CeleryDeliveryInfo{RoutingKey: "manual", Queue: "manual"},
+		},
+		{
+			name:     "no route",
+			task:     "video.upload",
+			expected: CeleryDeliveryInfo{RoutingKey: "celery", Exchange: "celery"},
+		},
+	}
+	for i, tc := range testCases {
+		if _, err := cli.DelayKwargs(context.Background(), TIMEOUT, tc.task, nil, tc.queue); err != nil {
+			t.Errorf("test '%s': failed to send task: %v", tc.name, err)
+			continue
+		}
+		broker.Lock()
+		message := broker.messages[i]
+		broker.Unlock()
+		if !reflect.DeepEqual(*message.DeliveryInfo, tc.expected) {
+			t.Errorf("test '%s': expected delivery info %+v, got %+v", tc.name, tc.expected, *message.DeliveryInfo)
+		}
+	}
+}
+
+// TestRoutesBroker tests that brokers without exchanges push routed tasks to their queue
+// and return consumed tasks to the queue they were received from
+func TestRoutesBroker(t *testing.T) {
+	ctx := context.Background()
+	folder := t.TempDir()
+	memoryBroker := NewMemoryCeleryBroker("video")
+	fsBroker, err := NewFilesystemCeleryBroker(folder, folder)
+	if err != nil {
+		t.Fatalf("failed to create broker: %v", err)
+	}
+	testCases := []struct {
+		name   string
+		broker interface {
+			CeleryBroker
+			QueueInspector
+		}
+	}{
+		{
+			name:   "memory broker",
+			broker: memoryBroker,
+		},
+		{
+			name:   "filesystem broker",
+			broker: fsBroker,
+		},
+	}
+	for _, tc := range testCases {
+		cli, _ := NewCeleryClient(tc.broker, NewMemoryCeleryBackend(), 1)
+		cli.SetRoutes(RouteGlob("video.*", Route{Queue: "video", Exchange: "media", RoutingKey: "media.video"}))
+		if _, err := cli.Delay(ctx, TIMEOUT, "video.encode"); err != nil {
+			t.Errorf("test '%s': failed to send task: %v", tc.name, err)
+			continue
+		}
+		for _, queue := range []string{"celery", "media.video"} {
+			if depth, _ := tc.broker.QueueDepth(ctx, queue); depth != 0 {
+				t.Errorf("test '%s': expected no message in queue %s, got %d", tc.name, queue, depth)
+			}
+		}
+		if depth, _ := tc.broker.QueueDepth(ctx, "video"); depth != 1 {
+			t.Errorf("test '%s': expected routed message in queue video, got %d", tc.name, depth)
+		}
+	}
+
+	// message consumed from routed queue is requeued back to it
+	message, err := memoryBroker.GetTaskMessage(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get routed message: %v", err)
+	}
+	if err := sendTaskMessage(ctx, memoryBroker, TIMEOUT, message); err != nil {
+		t.Fatalf("failed to requeue message: %v", err)
+	}
+	if depth, _ := memoryBroker.QueueDepth(ctx, "video"); depth != 1 {
+		t.Errorf("expected requeued message in queue video, got %d", depth)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQLDialect selects SQL syntax used by SQLCeleryBackend
+type SQLDialect int
+
+const (
+	// SQLDialectPostgres is dialect of PostgreSQL
+	SQLDialectPostgres SQLDialect = iota
+	// SQLDialectSQLite is dialect of SQLite
+	SQLDialectSQLite
+	// SQLDialectMySQL is dialect of MySQL and MariaDB
+	SQLDialectMySQL
+)
+
+// SQLCeleryBackend is celery backend storing results in celery_taskmeta table
+// of celery database backend. Group results are stored in celery_tasksetmeta table.
+// Result column holds pickle, like PickleType column of celery, of values
+// representable in json. This is how celery stores results with default json
+// result serializer, results pickled from other python objects cannot be read.
+type SQLCeleryBackend struct {
+	db           *sql.DB
+	dialect      SQLDialect
+	settingsLock sync.RWMutex
+	logger       Logger
+}
+
+// NewSQLCeleryBackend creates new SQLCeleryBackend using given database
+// Tables are not created, see Migrate.
+func NewSQLCeleryBackend(db *sql.DB, dialect SQLDialect) *SQLCeleryBackend {
+	return &SQLCeleryBackend{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// SetLogger sets logger used to report failed cleanups
+func (b *SQLCeleryBackend) SetLogger(logger Logger) {
+	b.settingsLock.Lock()
+	b.logger = logger
+	b.settingsLock.Unlock()
+}
+
+// getLogger returns logger of the backend
+func (b *SQLCeleryBackend) getLogger() Logger {
+	b.settingsLock.RLock()
+	defer b.settingsLock.RUnlock()
+	return loggerOrDefault(b.logger)
+}
+
+// Migrate creates celery_taskmeta and celery_tasksetmeta tables unless they exist
+// Tables match the schema created by celery database backend.
+func (b *SQLCeleryBackend) Migrate(ctx context.Context) error {
+	for _, stmt := range b.dialect.schema() {
+		if _, err := b.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to migrate schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetResult reads result of given task
+func (b *SQLCeleryBackend) GetResult(ctx context.Context, taskID string) (*ResultMessage, error) {
+	var status string
+	var result []byte
+	var traceback sql.NullString
+	err := b.db.QueryRowContext(ctx, b.dialect.rebind(
+		`SELECT status, result, traceback FROM celery_taskmeta WHERE task_id = ?`,
+	), taskID).Scan(&status, &result, &traceback)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("result not available")
+	}
+	if err != nil {
+		return nil, err
+	}
+	resultMessage := &ResultMessage{
+		ID:     taskID,
+		Status: status,
+	}
+	if traceback.Valid {
+		resultMessage.Traceback = traceback.String
+	}
+	if len(result) > 0 {
+		if resultMessage.Result, err = decodePickle(result); err != nil {
+			return nil, fmt.Errorf("failed to decode result of task %s: %w", taskID, err)
+		}
+	}
+	return resultMessage, nil
+}
+
+// SetResult stores result of given task, replacing previous result of the task
+func (b *SQLCeleryBackend) SetResult(ctx context.Context, taskID string, result *ResultMessage) error {
+	resBytes, err := encodePickle(result.Result)
+	if err != nil {
+		return err
+	}
+	var traceback sql.NullString
+	switch tb := result.Traceback.(type) {
+	case nil:
+	case string:
+		traceback = sql.NullString{String: tb, Valid: true}
+	default:
+		traceback = sql.NullString{String: fmt.Sprint(tb), Valid: true}
+	}
+	_, err = b.db.ExecContext(ctx, b.dialect.upsert(
+		"celery_taskmeta", "task_id", "status", "result", "date_done", "traceback",
+	), taskID, result.Status, resBytes, time.Now().UTC(), traceback)
+	return err
+}
+
+// DeleteResult removes result of given task
+func (b *SQLCeleryBackend) DeleteResult(ctx context.Context, taskID string) error {
+	_, err := b.db.ExecContext(ctx, b.dialect.rebind(
+		`DELETE FROM celery_taskmeta WHERE task_id = ?`,
+	), taskID)
+	return err
+}
+
+// SetGroupResult stores ids of tasks belonging to given group
+// Unlike celery, which pickles GroupResult object, ids are stored as pickled list.
+func (b *SQLCeleryBackend) SetGroupResult(ctx context.Context, groupID string, taskIDs []string) error {
+	resBytes, err := encodePickle(taskIDs)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.ExecContext(ctx, b.dialect.upsert(
+		"celery_tasksetmeta", "taskset_id", "result", "date_done",
+	), groupID, resBytes, time.Now().UTC())
+	return err
+}
+
+// GetGroupResult reads ids of tasks belonging to given group
+func (b *SQLCeleryBackend) GetGroupResult(ctx context.Context, groupID string) ([]string, error) {
+	var result []byte
+	err := b.db.QueryRowContext(ctx, b.dialect.rebind(
+		`SELECT result FROM celery_tasksetmeta WHERE taskset_id = ?`,
+	), groupID).Scan(&result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("group result not available")
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodePickle(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode result of group %s: %w", groupID, err)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("result of group %s is not list of task ids", groupID)
+	}
+	taskIDs := make([]string, len(items))
+	for i, item := range items {
+		if taskIDs[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("result of group %s is not list of task ids", groupID)
+		}
+	}
+	return taskIDs, nil
+}
+
+// Cleanup removes task and group results completed before expires ago
+// It is equivalent of celery.backend_cleanup task and returns number of removed rows.
+func (b *SQLCeleryBackend) Cleanup(ctx context.Context, expires time.Duration) (int64, error) {
+	cutoff := time.Now().UTC().Add(-expires)
+	var removed int64
+	for _, table := range []string{"celery_taskmeta", "celery_tasksetmeta"} {
+		res, err := b.db.ExecContext(ctx, b.dialect.rebind(
+			`DELETE FROM `+table+` WHERE date_done < ?`,
+		), cutoff)
+		if err != nil {
+			return removed, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// RunCleanup removes expired results every interval until ctx is done
+func (b *SQLCeleryBackend) RunCleanup(ctx context.Context, expires, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := b.Cleanup(ctx, expires); err != nil && ctx.Err() == nil {
+			b.getLogger().Error("failed to clean up expired results", "error", err)
+		}
+	}
+}
+
+// schema returns statements creating celery result tables
+func (d SQLDialect) schema() []string {
+	switch d {
+	case SQLDialectSQLite:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS celery_taskmeta (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task_id VARCHAR(155) UNIQUE,
+				status VARCHAR(50),
+				result BLOB,
+				date_done DATETIME,
+				traceback TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS celery_tasksetmeta (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				taskset_id VARCHAR(155) UNIQUE,
+				result BLOB,
+				date_done DATETIME
+			)`,
+		}
+	case SQLDialectMySQL:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS celery_taskmeta (
+				id INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				task_id VARCHAR(155) UNIQUE,
+				status VARCHAR(50),
+				result BLOB,
+				date_done DATETIME,
+				traceback TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS celery_tasksetmeta (
+				id INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				taskset_id VARCHAR(155) UNIQUE,
+				result BLOB,
+				date_done DATETIME
+			)`,
+		}
+	default:
+		return []string{
+			`CREATE SEQUENCE IF NOT EXISTS task_id_sequence`,
+			`CREATE TABLE IF NOT EXISTS celery_taskmeta (
+				id INTEGER NOT NULL DEFAULT nextval('task_id_sequence') PRIMARY KEY,
+				task_id VARCHAR(155) UNIQUE,
+				status VARCHAR(50),
+				result BYTEA,
+				date_done TIMESTAMP WITHOUT TIME ZONE,
+				traceback TEXT
+			)`,
+			`CREATE SEQUENCE IF NOT EXISTS taskset_id_sequence`,
+			`CREATE TABLE IF NOT EXISTS celery_tasksetmeta (
+				id INTEGER NOT NULL DEFAULT nextval('taskset_id_sequence') PRIMARY KEY,
+				taskset_id VARCHAR(155) UNIQUE,
+				result BYTEA,
+				date_done TIMESTAMP WITHOUT TIME ZONE
+			)`,
+		}
+	}
+}
+
+// upsert returns statement inserting row or updating row with the same key
+// Key is first of given columns.
+func (d SQLDialect) upsert(table string, columns ...string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt := "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + placeholders + ")"
+	updates := make([]string, len(columns)-1)
+	for i, column := range columns[1:] {
+		if d == SQLDialectMySQL {
+			updates[i] = column + " = VALUES(" + column + ")"
+		} else {
+			updates[i] = column + " = excluded." + column
+		}
+	}
+	if d == SQLDialectMySQL {
+		stmt += " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	} else {
+		stmt += " ON CONFLICT (" + columns[0] + ") DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return d.rebind(stmt)
+}
+
+// rebind replaces ? placeholders with numbered placeholders of postgres
+func (d SQLDialect) rebind(query string) string {
+	if d != SQLDialectPostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
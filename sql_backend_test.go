@@ -0,0 +1,197 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteBackend creates SQLCeleryBackend on migrated sqlite database
+func newSQLiteBackend(t *testing.T) *SQLCeleryBackend {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	backend := NewSQLCeleryBackend(db, SQLDialectSQLite)
+	for i := 0; i < 2; i++ {
+		if err := backend.Migrate(context.Background()); err != nil {
+			t.Fatalf("failed to migrate database: %v", err)
+		}
+	}
+	return backend
+}
+
+// TestSQLBackendResult tests storing, replacing and deleting task results
+func TestSQLBackendResult(t *testing.T) {
+	ctx := context.Background()
+	backend := newSQLiteBackend(t)
+	if _, err := backend.GetResult(ctx, "missing"); err == nil {
+		t.Errorf("result of unknown task should not be available")
+	}
+
+	testCases := []struct {
+		name   string
+		result *ResultMessage
+	}{
+		{
+			name:   "pending result",
+			result: &ResultMessage{Status: "STARTED"},
+		},
+		{
+			name:   "successful result",
+			result: &ResultMessage{Status: "SUCCESS", Result: 3.0},
+		},
+		{
+			name: "failure result",
+			result: &ResultMessage{
+				Status:    "FAILURE",
+				Result:    map[string]interface{}{"exc_type": "ValueError", "exc_message": []interface{}{"bad"}, "exc_module": "builtins"},
+				Traceback: "Traceback (most recent call last)",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		if err := backend.SetResult(ctx, "task-id", tc.result); err != nil {
+			t.Errorf("test '%s': failed to set result: %v", tc.name, err)
+			continue
+		}
+		result, err := backend.GetResult(ctx, "task-id")
+		if err != nil {
+			t.Errorf("test '%s': failed to get result: %v", tc.name, err)
+			continue
+		}
+		tc.result.ID = "task-id"
+		if !reflect.DeepEqual(result, tc.result) {
+			t.Errorf("test '%s': expected result %+v, got %+v", tc.name, tc.result, result)
+		}
+	}
+
+	var rows int
+	if err := backend.db.QueryRow(`SELECT COUNT(*) FROM celery_taskmeta`).Scan(&rows); err != nil || rows != 1 {
+		t.Errorf("expected single row for task, got %d: %v", rows, err)
+	}
+	if err := backend.DeleteResult(ctx, "task-id"); err != nil {
+		t.Errorf("failed to delete result: %v", err)
+	}
+	if _, err := backend.GetResult(ctx, "task-id"); err == nil {
+		t.Errorf("deleted result should not be available")
+	}
+}
+
+// TestSQLBackendPythonResult tests reading result pickled by celery and writing pickled result
+func TestSQLBackendPythonResult(t *testing.T) {
+	ctx := context.Background()
+	backend := newSQLiteBackend(t)
+	// pickle.dumps({"exc_type": "ValueError", "exc_message": ("bad",), "exc_module": "builtins"}, protocol=5)
+	pickled, _ := hex.DecodeString("8005954b000000000000007d94288c086578635f74797065948c0a56616c75654572726f72948c0b6578635f6d657373616765948c036261649485948c0a6578635f6d6f64756c65948c086275696c74696e7394752e")
+	if _, err := backend.db.Exec(
+		`INSERT INTO celery_taskmeta (task_id, status, result, date_done, traceback) VALUES (?, ?, ?, ?, ?)`,
+		"python-task", "FAILURE", pickled, time.Now().UTC(), "Traceback",
+	); err != nil {
+		t.Fatalf("failed to insert result: %v", err)
+	}
+	result, err := backend.GetResult(ctx, "python-task")
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	expected := map[string]interface{}{"exc_type": "ValueError", "exc_message": []interface{}{"bad"}, "exc_module": "builtins"}
+	if result.Status != "FAILURE" || !reflect.DeepEqual(result.Result, expected) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if err := backend.SetResult(ctx, "go-task", &ResultMessage{Status: "SUCCESS", Result: "done"}); err != nil {
+		t.Fatalf("failed to set result: %v", err)
+	}
+	var stored []byte
+	if err := backend.db.QueryRow(`SELECT result FROM celery_taskmeta WHERE task_id = 'go-task'`).Scan(&stored); err != nil {
+		t.Fatalf("failed to read result: %v", err)
+	}
+	// pickle.loads of stored result returns "done"
+	if hex.EncodeToString(stored) != "80025804000000646f6e652e" {
+		t.Errorf("unexpected pickled result %x", stored)
+	}
+}
+
+// TestSQLBackendCleanup tests removing expired task and group results
+func TestSQLBackendCleanup(t *testing.T) {
+	ctx := context.Background()
+	backend := newSQLiteBackend(t)
+	for _, taskID := range []string{"old", "new"} {
+		if err := backend.SetResult(ctx, taskID, &ResultMessage{Status: "SUCCESS"}); err != nil {
+			t.Fatalf("failed to set result: %v", err)
+		}
+	}
+	if err := backend.SetGroupResult(ctx, "group", []string{"old", "new"}); err != nil {
+		t.Fatalf("failed to set group result: %v", err)
+	}
+	if taskIDs, err := backend.GetGroupResult(ctx, "group"); err != nil || !reflect.DeepEqual(taskIDs, []string{"old", "new"}) {
+		t.Errorf("unexpected group result %v: %v", taskIDs, err)
+	}
+	past := time.Now().UTC().Add(-48 * time.Hour)
+	for _, stmt := range []string{
+		`UPDATE celery_taskmeta SET date_done = ? WHERE task_id = 'old'`,
+		`UPDATE celery_tasksetmeta SET date_done = ?`,
+	} {
+		if _, err := backend.db.Exec(stmt, past); err != nil {
+			t.Fatalf("failed to age results: %v", err)
+		}
+	}
+
+	removed, err := backend.Cleanup(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to clean up results: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 removed results, got %d", removed)
+	}
+	if _, err := backend.GetResult(ctx, "old"); err == nil {
+		t.Errorf("expired result should be removed")
+	}
+	if _, err := backend.GetResult(ctx, "new"); err != nil {
+		t.Errorf("recent result should be kept: %v", err)
+	}
+	if _, err := backend.GetGroupResult(ctx, "group"); err == nil {
+		t.Errorf("expired group result should be removed")
+	}
+}
+
+// TestSQLDialectQueries tests statements generated for each dialect
+func TestSQLDialectQueries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dialect  SQLDialect
+		expected string
+	}{
+		{
+			name:     "postgres",
+			dialect:  SQLDialectPostgres,
+			expected: "INSERT INTO t (k, a, b) VALUES ($1, $2, $3) ON CONFLICT (k) DO UPDATE SET a = excluded.a, b = excluded.b",
+		},
+		{
+			name:     "sqlite",
+			dialect:  SQLDialectSQLite,
+			expected: "INSERT INTO t (k, a, b) VALUES (?, ?, ?) ON CONFLICT (k) DO UPDATE SET a = excluded.a, b = excluded.b",
+		},
+		{
+			name:     "mysql",
+			dialect:  SQLDialectMySQL,
+			expected: "INSERT INTO t (k, a, b) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE a = VALUES(a), b = VALUES(b)",
+		},
+	}
+	for _, tc := range testCases {
+		if stmt := tc.dialect.upsert("t", "k", "a", "b"); stmt != tc.expected {
+			t.Errorf("test '%s': expected %q, got %q", tc.name, tc.expected, stmt)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// defaultSendTimeout is used to send typed tasks when context has no deadline
+const defaultSendTimeout = 10 * time.Second
+
+// TaskDef is typed task definition which can be shared between client and worker code
+// Args must be a struct - it is sent as keyword arguments using its json field names.
+type TaskDef[Args any, Result any] struct {
+	name   string
+	client *CeleryClient
+}
+
+// NewTaskDef creates new TaskDef for task with given name
+func NewTaskDef[Args any, Result any](name string) *TaskDef[Args, Result] {
+	return &TaskDef[Args, Result]{
+		name: name,
+	}
+}
+
+// Name returns name of the task
+func (d *TaskDef[Args, Result]) Name() string {
+	return d.name
+}
+
+// Register registers task function implementing this task definition
+func (d *TaskDef[Args, Result]) Register(r TaskRegistrar, fn TypedTaskFunc[Args, Result]) {
+	RegisterTyped(r, d.name, fn)
+}
+
+// Bind returns copy of task definition sending tasks with given client
+func (d *TaskDef[Args, Result]) Bind(cli *CeleryClient) *TaskDef[Args, Result] {
+	return &TaskDef[Args, Result]{
+		name:   d.name,
+		client: cli,
+	}
+}
+
+// Delay sends task with given arguments and returns typed asynchronous result
+func (d *TaskDef[Args, Result]) Delay(ctx context.Context, args Args) (*TypedAsyncResult[Result], error) {
+	if d.client == nil {
+		return nil, fmt.Errorf("task %s is not bound to celery client", d.name)
+	}
+	kwargs, err := encodeTaskArgs(args)
+	if err != nil {
+		return nil, fmt.Errorf("task %s: %w", d.name, err)
+	}
+	timeout := defaultSendTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	asyncResult, err := d.client.DelayKwargs(ctx, timeout, d.name, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedAsyncResult[Result]{AsyncResult: asyncResult}, nil
+}
+
+// encodeTaskArgs encodes Args struct into keyword arguments
+func encodeTaskArgs(args interface{}) (map[string]interface{}, error) {
+	argBytes, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var kwargs map[string]interface{}
+	if err := json.Unmarshal(argBytes, &kwargs); err != nil {
+		return nil, fmt.Errorf("task arguments must be encoded as json object: %w", err)
+	}
+	return kwargs, nil
+}
+
+// TypedAsyncResult represents pending result of typed task
+type TypedAsyncResult[Result any] struct {
+	*AsyncResult
+}
+
+// Get gets actual result from backend decoded into Result
+// It blocks for period of time set by timeout and returns error if unavailable
+func (ar *TypedAsyncResult[Result]) Get(ctx context.Context, timeout time.Duration) (Result, error) {
+	val, err := ar.AsyncResult.Get(ctx, timeout)
+	if err != nil {
+		var res Result
+		return res, err
+	}
+	return decodeResult[Result](val)
+}
+
+// AsyncGet gets actual result decoded into Result and returns error if not available
+func (ar *TypedAsyncResult[Result]) AsyncGet(ctx context.Context) (Result, error) {
+	var res Result
+	val, err := ar.AsyncResult.AsyncGet(ctx)
+	if err != nil {
+		return res, err
+	}
+	if ar.AsyncResult.result == nil {
+		return res, fmt.Errorf("result not available")
+	}
+	return decodeResult[Result](val)
+}
+
+// decodeResult converts result decoded from backend into Result
+func decodeResult[Result any](val interface{}) (Result, error) {
+	var res Result
+	if typed, ok := val.(Result); ok {
+		return typed, nil
+	}
+	resBytes, err := json.Marshal(val)
+	if err != nil {
+		return res, err
+	}
+	if err := json.Unmarshal(resBytes, &res); err != nil {
+		return res, fmt.Errorf("failed to decode result: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// SpanKind describes relationship of span to its remote parent or children
+type SpanKind int
+
+// Span kinds created by client and worker
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindProducer
+	SpanKindConsumer
+)
+
+// Tracer creates spans around publishing, consuming and executing tasks
+// It can be implemented on top of any tracing library.
+type Tracer interface {
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+// Span represents single traced operation
+type Span interface {
+	SpanContext() SpanContext
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// SpanData holds recorded span exported once the span ends
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	Parent      SpanContext
+	StartTime   time.Time
+	EndTime     time.Time
+	Attributes  map[string]interface{}
+	Err         error
+}
+
+// SpanExporter receives ended spans
+type SpanExporter interface {
+	ExportSpan(span SpanData)
+}
+
+// NewTracer creates Tracer recording spans with W3C trace context identifiers
+// and passing them to exporter when they end
+func NewTracer(exporter SpanExporter) Tracer {
+	return &recordingTracer{exporter: exporter}
+}
+
+type recordingTracer struct {
+	exporter SpanExporter
+}
+
+// Start starts span continuing trace of span context carried by ctx
+func (t *recordingTracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	parent, hasParent := SpanContextFromContext(ctx)
+	sc := SpanContext{Flags: 0x01}
+	if hasParent {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	span := &recordingSpan{
+		exporter: t.exporter,
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent,
+			StartTime:   time.Now(),
+			Attributes:  map[string]interface{}{},
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), span
+}
+
+type recordingSpan struct {
+	sync.Mutex
+	exporter SpanExporter
+	data     SpanData
+	ended    bool
+}
+
+func (s *recordingSpan) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.Lock()
+	s.data.Attributes[key] = value
+	s.Unlock()
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.Lock()
+	s.data.Err = err
+	s.Unlock()
+}
+
+func (s *recordingSpan) End() {
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.Unlock()
+	if s.exporter != nil {
+		s.exporter.ExportSpan(data)
+	}
+}
+
+// InMemoryExporter keeps exported spans in memory, mostly useful in tests
+type InMemoryExporter struct {
+	sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpan stores ended span
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.Lock()
+	e.spans = append(e.spans, span)
+	e.Unlock()
+}
+
+// Spans returns copy of stored spans in order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.Lock()
+	defer e.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes stored spans
+func (e *InMemoryExporter) Reset() {
+	e.Lock()
+	e.spans = nil
+	e.Unlock()
+}
+
+// noopSpan is used when no tracer is configured
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (s noopSpan) SpanContext() SpanContext                   { return s.sc }
+func (s noopSpan) SetAttribute(key string, value interface{}) {}
+func (s noopSpan) RecordError(err error)                      {}
+func (s noopSpan) End()                                       {}
+
+// startSpan starts span with given tracer annotated with task attributes
+// returning no-op span if tracer is nil
+func startSpan(ctx context.Context, tracer Tracer, name string, kind SpanKind, message *TaskMessage) (context.Context, Span) {
+	if tracer == nil {
+		sc, _ := SpanContextFromContext(ctx)
+		return ctx, noopSpan{sc: sc}
+	}
+	ctx, span := tracer.Start(ctx, name+" "+message.Task, kind)
+	span.SetAttribute("messaging.system", "celery")
+	span.SetAttribute("celery.task_name", message.Task)
+	span.SetAttribute("celery.task_id", message.ID)
+	if message.DeliveryInfo != nil {
+		span.SetAttribute("messaging.destination.name", message.DeliveryInfo.RoutingKey)
+	}
+	return ctx, span
+}
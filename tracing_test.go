@@ -0,0 +1,111 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PerformLine/go-stockutil/stringutil"
+)
+
+type requestIDKey struct{}
+
+// TestParseTraceParent tests parsing of W3C traceparent header
+func TestParseTraceParent(t *testing.T) {
+	testCases := []struct {
+		name        string
+		traceParent string
+		hasError    bool
+	}{
+		{name: "valid traceparent", traceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "future version with extra fields", traceParent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "invalid version", traceParent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", hasError: true},
+		{name: "zero trace ID", traceParent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", hasError: true},
+		{name: "short span ID", traceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", hasError: true},
+		{name: "non hex trace ID", traceParent: "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", hasError: true},
+	}
+	for _, tc := range testCases {
+		sc, err := ParseTraceParent(tc.traceParent)
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("test '%s': expected error but parsed %+v", tc.name, sc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test '%s': failed to parse traceparent: %v", tc.name, err)
+			continue
+		}
+		if sc.TraceParent()[3:] != tc.traceParent[3:55] {
+			t.Errorf("test '%s': formatted traceparent %s is different from %s", tc.name, sc.TraceParent(), tc.traceParent)
+		}
+	}
+}
+
+// TestTracePropagation tests propagation of trace and request ID from client into task
+func TestTracePropagation(t *testing.T) {
+	cli := newLoopbackClient()
+
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+	cli.SetTracer(tracer)
+	cli.SetPropagator(CompositePropagator{
+		TraceContextPropagator{},
+		ValuePropagator{Header: "request_id", Key: requestIDKey{}},
+	})
+
+	taskName := stringutil.UUID().String()
+	cli.Register(taskName, func(ctx context.Context) (string, error) {
+		requestID, _ := ctx.Value(requestIDKey{}).(string)
+		return requestID, nil
+	})
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "request-1")
+	ctx, root := tracer.Start(ctx, "request", SpanKindInternal)
+	asyncResult, err := cli.Delay(ctx, TIMEOUT, taskName)
+	if err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	root.End()
+	res, err := asyncResult.Get(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if res != "request-1" {
+		t.Errorf("request ID %v was not propagated into task", res)
+	}
+
+	spans := exporter.Spans()
+	expected := []struct {
+		name string
+		kind SpanKind
+	}{
+		{"execute " + taskName, SpanKindInternal},
+		{"consume " + taskName, SpanKindConsumer},
+		{"publish " + taskName, SpanKindProducer},
+		{"request", SpanKindInternal},
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("expected %d spans but received %d", len(expected), len(spans))
+	}
+	for i, span := range spans {
+		if span.Name != expected[i].name || span.Kind != expected[i].kind {
+			t.Errorf("span %d %s (%d) is different from expected %s (%d)", i, span.Name, span.Kind, expected[i].name, expected[i].kind)
+		}
+		if span.SpanContext.TraceID != root.SpanContext().TraceID {
+			t.Errorf("span %s does not belong to trace of the caller", span.Name)
+		}
+		if i < len(spans)-1 && span.Parent.SpanID != spans[i+1].SpanContext.SpanID {
+			t.Errorf("span %s is not child of span %s", span.Name, spans[i+1].Name)
+		}
+	}
+	if !spans[1].Parent.Remote {
+		t.Errorf("consume span should have remote parent extracted from headers")
+	}
+	if spans[2].Attributes["celery.task_id"] != asyncResult.TaskID() {
+		t.Errorf("publish span is missing task ID attribute: %+v", spans[2].Attributes)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TaskRegistrar registers tasks by name
+// Both CeleryClient and CeleryWorker implement this interface.
+type TaskRegistrar interface {
+	Register(name string, task interface{})
+}
+
+// TypedTaskFunc is type-safe task function
+// Args is decoded from task message and Result is stored as is in backend.
+type TypedTaskFunc[Args any, Result any] func(ctx context.Context, args Args) (Result, error)
+
+// ArgsValidator can be implemented by Args of typed tasks
+// to validate arguments after they are decoded
+type ArgsValidator interface {
+	Validate() error
+}
+
+// RegisterTyped registers type-safe task function
+// Args must be a struct - positional arguments are assigned to its exported fields
+// in order of declaration and keyword arguments to fields matching their json names.
+func RegisterTyped[Args any, Result any](r TaskRegistrar, name string, fn TypedTaskFunc[Args, Result]) {
+	r.Register(name, &typedTask[Args, Result]{fn: fn})
+}
+
+// typedTask executes TypedTaskFunc directly from task message
+// keeping no state between executions
+type typedTask[Args any, Result any] struct {
+	fn TypedTaskFunc[Args, Result]
+}
+
+func (t *typedTask[Args, Result]) runTaskMessage(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
+	args, err := DecodeTaskArgs[Args](message.Args, message.Kwargs)
+	if err != nil {
+		return getFailureResultMessage("TypeError", "builtins", fmt.Sprintf("task %s: %v", message.Task, err)), nil
+	}
+	res, err := t.fn(ctx, args)
+	if err != nil {
+		var retryErr *RetryError
+		if errors.As(err, &retryErr) {
+			return nil, err
+		}
+		return getErrorResultMessage(err), nil
+	}
+	return getResultMessage(res), nil
+}
+
+// ArgumentError describes task argument which could not be decoded
+type ArgumentError struct {
+	Argument string
+	Err      error
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("invalid argument %s: %v", e.Argument, e.Err)
+}
+
+func (e *ArgumentError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeTaskArgs decodes positional and keyword arguments into Args struct
+// Values are converted using encoding/json so that json numbers are decoded
+// into any numeric field and mismatching values are reported as ArgumentError.
+func DecodeTaskArgs[Args any](args []interface{}, kwargs map[string]interface{}) (Args, error) {
+	var decoded Args
+	val := reflect.ValueOf(&decoded).Elem()
+	if val.Kind() != reflect.Struct {
+		return decoded, fmt.Errorf("task arguments must be decoded into struct, not %s", val.Type())
+	}
+	fields := argFields(val)
+
+	if len(args) > len(fields) {
+		return decoded, fmt.Errorf("task takes %d arguments but %d positional arguments were given", len(fields), len(args))
+	}
+	for i, arg := range args {
+		if err := decodeArg(fields[i].value, arg); err != nil {
+			return decoded, &ArgumentError{Argument: fields[i].name, Err: err}
+		}
+	}
+	for name, kwarg := range kwargs {
+		idx := -1
+		for i, field := range fields {
+			if field.name == name {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return decoded, &ArgumentError{Argument: name, Err: fmt.Errorf("unexpected keyword argument")}
+		}
+		if idx < len(args) {
+			return decoded, &ArgumentError{Argument: name, Err: fmt.Errorf("multiple values given")}
+		}
+		if err := decodeArg(fields[idx].value, kwarg); err != nil {
+			return decoded, &ArgumentError{Argument: name, Err: err}
+		}
+	}
+
+	if validator, ok := any(&decoded).(ArgsValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return decoded, err
+		}
+	} else if validator, ok := any(decoded).(ArgsValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return decoded, err
+		}
+	}
+	return decoded, nil
+}
+
+type argField struct {
+	name  string
+	value reflect.Value
+}
+
+// argFields lists settable struct fields in order of declaration
+// using their json names, fields of embedded structs are flattened
+func argFields(val reflect.Value) []argField {
+	var fields []argField
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, argFields(val.Field(i))...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, argField{name: name, value: val.Field(i)})
+	}
+	return fields
+}
+
+func decodeArg(field reflect.Value, arg interface{}) error {
+	argBytes, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(argBytes, field.Addr().Interface())
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2019 Sick Yoon
+// This file is part of gocelery which is released under MIT license.
+// See file LICENSE for full license details.
+
+package gocelery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PerformLine/go-stockutil/stringutil"
+)
+
+type typedAddArgs struct {
+	A     int     `json:"a"`
+	B     int64   `json:"b"`
+	Scale float32 `json:"scale,omitempty"`
+}
+
+func (args *typedAddArgs) Validate() error {
+	if args.A < 0 {
+		return fmt.Errorf("a must not be negative")
+	}
+	return nil
+}
+
+type typedAddResult struct {
+	Sum float32 `json:"sum"`
+}
+
+func typedAdd(ctx context.Context, args typedAddArgs) (typedAddResult, error) {
+	scale := args.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return typedAddResult{Sum: float32(int64(args.A)+args.B) * scale}, nil
+}
+
+// TestDecodeTaskArgs tests decoding of positional and keyword arguments into struct
+func TestDecodeTaskArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []interface{}
+		kwargs   map[string]interface{}
+		expected typedAddArgs
+		argError string
+		hasError bool
+	}{
+		{
+			name:     "positional arguments",
+			args:     []interface{}{float64(1), float64(2)},
+			expected: typedAddArgs{A: 1, B: 2},
+		},
+		{
+			name:     "keyword arguments",
+			kwargs:   map[string]interface{}{"a": float64(3), "b": float64(4), "scale": 0.5},
+			expected: typedAddArgs{A: 3, B: 4, Scale: 0.5},
+		},
+		{
+			name:     "positional and keyword arguments",
+			args:     []interface{}{float64(5)},
+			kwargs:   map[string]interface{}{"b": float64(6)},
+			expected: typedAddArgs{A: 5, B: 6},
+		},
+		{
+			name:     "fractional number for integer argument",
+			args:     []interface{}{1.5, float64(2)},
+			argError: "a",
+			hasError: true,
+		},
+		{
+			name:     "string for integer argument",
+			kwargs:   map[string]interface{}{"b": "two"},
+			argError: "b",
+			hasError: true,
+		},
+		{
+			name:     "unexpected keyword argument",
+			kwargs:   map[string]interface{}{"c": float64(1)},
+			argError: "c",
+			hasError: true,
+		},
+		{
+			name:     "duplicate argument",
+			args:     []interface{}{float64(1)},
+			kwargs:   map[string]interface{}{"a": float64(1)},
+			argError: "a",
+			hasError: true,
+		},
+		{
+			name:     "too many positional arguments",
+			args:     []interface{}{float64(1), float64(2), float64(3), float64(4)},
+			hasError: true,
+		},
+		{
+			name:     "failed validation",
+			args:     []interface{}{float64(-1), float64(2)},
+			hasError: true,
+		},
+	}
+	for _, tc := range testCases {
+		args, err := DecodeTaskArgs[typedAddArgs](tc.args, tc.kwargs)
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("test '%s': expected error but decoded %+v", tc.name, args)
+				continue
+			}
+			var argErr *ArgumentError
+			if tc.argError != "" && (!errors.As(err, &argErr) || argErr.Argument != tc.argError) {
+				t.Errorf("test '%s': expected error for argument %s but received %v", tc.name, tc.argError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test '%s': failed to decode arguments: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tc.expected) {
+			t.Errorf("test '%s': decoded arguments %+v are different from expected %+v", tc.name, args, tc.expected)
+		}
+	}
+}
+
+// TestWorkerRunTypedTask tests execution of typed task registered with RegisterTyped
+func TestWorkerRunTypedTask(t *testing.T) {
+	celeryWorker := NewCeleryWorker(nil, nil, 1)
+	taskName := stringutil.UUID().String()
+	RegisterTyped(celeryWorker, taskName, typedAdd)
+	taskMessage := &TaskMessage{
+		ID:     stringutil.UUID().String(),
+		Task:   taskName,
+		Args:   []interface{}{float64(2)},
+		Kwargs: map[string]interface{}{"b": float64(3), "scale": float64(2)},
+	}
+	resultMsg, err := celeryWorker.RunTask(taskMessage)
+	if err != nil {
+		t.Fatalf("failed to run typed task: %v", err)
+	}
+	expected := typedAddResult{Sum: 10}
+	if !reflect.DeepEqual(resultMsg.Result, expected) {
+		t.Errorf("typed task result %+v is different from expected %+v", resultMsg.Result, expected)
+	}
+}
+
+// TestTaskDefDelay tests sending typed task and decoding its typed result
+func TestTaskDefDelay(t *testing.T) {
+	cli := newLoopbackClient()
+
+	addTask := NewTaskDef[typedAddArgs, typedAddResult](stringutil.UUID().String())
+	addTask.Register(cli, typedAdd)
+
+	ctx := context.Background()
+	if _, err := addTask.Delay(ctx, typedAddArgs{A: 1, B: 2}); err == nil {
+		t.Errorf("expected error sending task not bound to client")
+	}
+	asyncResult, err := addTask.Bind(cli).Delay(ctx, typedAddArgs{A: 1, B: 2, Scale: 1.5})
+	if err != nil {
+		t.Fatalf("failed to send typed task: %v", err)
+	}
+	res, err := asyncResult.Get(ctx, TIMEOUT)
+	if err != nil {
+		t.Fatalf("failed to get typed result: %v", err)
+	}
+	expected := typedAddResult{Sum: 4.5}
+	if res != expected {
+		t.Errorf("typed result %+v is different from expected %+v", res, expected)
+	}
+}
+
+// TestTaskDefFailure tests that typed task failures are stored as FAILURE results
+func TestTaskDefFailure(t *testing.T) {
+	cli := newLoopbackClient()
+
+	addTask := NewTaskDef[typedAddArgs, typedAddResult](stringutil.UUID().String()).Bind(cli)
+	addTask.Register(cli, func(ctx context.Context, args typedAddArgs) (typedAddResult, error) {
+		if args.B == 0 {
+			return typedAddResult{}, &ExceptionInfo{Type: "ZeroDivisionError", Module: "builtins"}
+		}
+		return typedAdd(ctx, args)
+	})
+
+	testCases := []struct {
+		name    string
+		args    typedAddArgs
+		excType string
+	}{
+		{
+			name:    "failed validation",
+			args:    typedAddArgs{A: -1, B: 2},
+			excType: "TypeError",
+		},
+		{
+			name:    "task error",
+			args:    typedAddArgs{A: 1},
+			excType: "ZeroDivisionError",
+		},
+	}
+	ctx := context.Background()
+	for _, tc := range testCases {
+		asyncResult, err := addTask.Delay(ctx, tc.args)
+		if err != nil {
+			t.Errorf("test '%s': failed to send typed task: %v", tc.name, err)
+			continue
+		}
+		started := time.Now()
+		_, err = asyncResult.Get(ctx, TIMEOUT)
+		var excInfo *ExceptionInfo
+		if !errors.As(err, &excInfo) || excInfo.Type != tc.excType {
+			t.Errorf("test '%s': expected %s failure, got %v", tc.name, tc.excType, err)
+		}
+		if time.Since(started) >= TIMEOUT {
+			t.Errorf("test '%s': failure was not stored", tc.name)
+		}
+	}
+}
+
+// loopbackBroker runs sent tasks with worker and stores results in backend
+type loopbackBroker struct {
+	worker *CeleryWorker
+}
+
+func (b *loopbackBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
+	b.worker.processTask(ctx, ctx, message.GetTaskMessage(ctx, timeout))
+	return nil
+}
+
+func (b *loopbackBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	return nil, fmt.Errorf("loopback broker does not queue messages")
+}
+
+// newLoopbackClient creates client running sent tasks right away and keeping results in memory
+func newLoopbackClient() *CeleryClient {
+	broker := &loopbackBroker{}
+	cli, _ := NewCeleryClient(broker, NewMemoryCeleryBackend(), 1)
+	broker.worker = cli.worker
+	return cli
+}
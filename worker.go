@@ -6,13 +6,69 @@ package gocelery
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"os/signal"
 	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// TimeLimits stores soft and hard execution time limits of a task
+// Soft limit cancels context of the task so that it can clean up,
+// hard limit abandons the task and records TimeLimitExceeded failure.
+// Zero value disables respective limit.
+type TimeLimits struct {
+	Soft time.Duration
+	Hard time.Duration
+}
+
+// messageTask is task executed directly from task message
+// without keeping parsed arguments in shared state
+type messageTask interface {
+	runTaskMessage(ctx context.Context, message *TaskMessage) (*ResultMessage, error)
+}
+
+// PanicHandler is called with recovered value and stack trace of panicking task
+type PanicHandler func(message *TaskMessage, recovered interface{}, stack []byte)
+
+// ErrTaskNotRegistered is returned for messages of tasks not registered by worker
+var ErrTaskNotRegistered = errors.New("task is not registered")
+
+// UnregisteredTaskAction is action taken on message of task not registered by worker
+type UnregisteredTaskAction int
+
+// actions taken on messages of unregistered tasks
+const (
+	// UnregisteredTaskRequeue sends message back to broker with ETA delayed
+	// for another worker to pick up, it is the default action
+	UnregisteredTaskRequeue UnregisteredTaskAction = iota
+	// UnregisteredTaskReject dead-letters message, NotRegistered FAILURE result
+	// of the task is stored instead if dead letter queue is not configured
+	UnregisteredTaskReject
+	// UnregisteredTaskFail stores NotRegistered FAILURE result of the task
+	UnregisteredTaskFail
+)
+
+// UnregisteredTaskPolicy configures handling of messages of unregistered tasks
+// Delay applies to UnregisteredTaskRequeue action, it defaults to 10 seconds.
+type UnregisteredTaskPolicy struct {
+	Action UnregisteredTaskAction
+	Delay  time.Duration
+}
+
+// defaultUnregisteredTaskDelay is delay of requeued messages of unregistered tasks
+const defaultUnregisteredTaskDelay = 10 * time.Second
+
+// ErrSoftTimeLimitExceeded is context cause of task exceeding its soft time limit
+var ErrSoftTimeLimitExceeded = errors.New("soft time limit exceeded")
+
 // CeleryWorker represents distributed task worker
 type CeleryWorker struct {
 	broker          CeleryBroker
@@ -21,7 +77,34 @@ type CeleryWorker struct {
 	registeredTasks map[string]interface{}
 	taskLock        sync.RWMutex
 	cancel          context.CancelFunc
+	runCancel       context.CancelFunc
 	workWG          sync.WaitGroup
+	poolLock        sync.Mutex
+	pool            []chan struct{}
+	nextWorkerID    int
+	wctx            context.Context
+	runCtx          context.Context
+	deliveries      <-chan *TaskMessage
+	due             chan *TaskMessage
+	autoscale       *Autoscale
+	prefetch        int
+	deadLetters     DeadLetterQueue
+	unregistered    UnregisteredTaskPolicy
+	inFlightLock    sync.Mutex
+	inFlight        map[string]string
+	lostTasks       []string
+	timeLimits      TimeLimits
+	taskTimeLimits  map[string]TimeLimits
+	abandonedTasks  atomic.Int64
+	panickedTasks   atomic.Int64
+	panicHandler    PanicHandler
+	middlewares     []ExecuteMiddleware
+	signals         workerSignals
+	timeout         time.Duration
+	propagator      Propagator
+	tracer          Tracer
+	metrics         *Metrics
+	logger          Logger
 }
 
 // NewCeleryWorker returns new celery worker
@@ -31,48 +114,240 @@ func NewCeleryWorker(broker CeleryBroker, backend CeleryBackend, numWorkers int)
 		backend:         backend,
 		numWorkers:      numWorkers,
 		registeredTasks: map[string]interface{}{},
+		taskTimeLimits:  map[string]TimeLimits{},
+		inFlight:        map[string]string{},
 	}
 }
 
 // StartWorkerWithContext starts celery worker(s) with given parent context
+// Cancelling ctx stops fetching new messages and cancels running tasks.
 func (w *CeleryWorker) StartWorkerWithContext(ctx context.Context, timeout time.Duration) {
-	var wctx context.Context
-	wctx, w.cancel = context.WithCancel(ctx)
-	w.workWG.Add(w.numWorkers)
-
-	for i := 0; i < w.numWorkers; i++ {
-		go func(workerID int) {
-			defer w.workWG.Done()
-			for {
-				select {
-				case <-wctx.Done():
-					return
-				default:
-
-					// process task request
-					taskMessage, err := w.broker.GetTaskMessage(ctx, timeout)
-					if err != nil || taskMessage == nil {
-						continue
-					}
-
-					// run task
-					resultMsg, err := w.RunTask(taskMessage)
-					if err != nil {
-						log.Printf("failed to run task message %s: %+v", taskMessage.ID, err)
-						continue
-					}
-					defer releaseResultMessage(resultMsg)
-
-					// push result to backend
-					err = w.backend.SetResult(ctx, taskMessage.ID, resultMsg)
-					if err != nil {
-						log.Printf("failed to push result: %+v", err)
-						continue
-					}
-				}
+	var wctx, runCtx context.Context
+	runCtx, w.runCancel = context.WithCancel(ctx)
+	wctx, w.cancel = context.WithCancel(runCtx)
+	w.timeout = timeout
+	w.configurePrefetch()
+
+	deliveries, err := NewPollingBroker(w.broker, 0).ConsumeTaskMessages(wctx, timeout)
+	if err != nil {
+		w.getLogger().Error("failed to consume task messages", "error", err)
+		return
+	}
+	w.workWG.Add(1)
+	go w.drain(wctx, runCtx, deliveries)
+
+	w.poolLock.Lock()
+	w.wctx, w.runCtx, w.deliveries, w.due = wctx, runCtx, deliveries, make(chan *TaskMessage)
+	numWorkers := w.numWorkers
+	w.numWorkers, w.pool = 0, nil
+	w.growLocked(numWorkers)
+	autoscale := w.autoscale
+	w.poolLock.Unlock()
+
+	if autoscale != nil {
+		w.workWG.Add(1)
+		go w.autoscaleLoop(wctx, *autoscale)
+	}
+}
+
+// configurePrefetch sets prefetch count of broker to prefetch multiplier times number of workers
+func (w *CeleryWorker) configurePrefetch() {
+	w.poolLock.Lock()
+	count := w.prefetch * w.numWorkers
+	if w.autoscale != nil {
+		count = w.prefetch * w.autoscale.Max
+	}
+	w.poolLock.Unlock()
+	if count < 1 {
+		return
+	}
+	broker, ok := w.broker.(prefetchingBroker)
+	if !ok {
+		return
+	}
+	if err := broker.SetPrefetchCount(count); err != nil {
+		w.getLogger().Error("failed to set prefetch count", "prefetch_count", count, "error", err)
+	}
+}
+
+// SetPrefetchMultiplier sets how many messages per worker goroutine are prefetched from broker
+// It must be called before workers are started, zero keeps default prefetch count of broker.
+func (w *CeleryWorker) SetPrefetchMultiplier(multiplier int) {
+	w.poolLock.Lock()
+	w.prefetch = multiplier
+	w.poolLock.Unlock()
+}
+
+// prefetchingBroker is broker fetching multiple messages ahead of processing
+type prefetchingBroker interface {
+	SetPrefetchCount(count int) error
+}
+
+// work processes delivered task messages until worker stops or stop is closed
+// Messages with ETA in the future are held until due and then processed from due.
+func (w *CeleryWorker) work(wctx, runCtx context.Context, workerID int, stop <-chan struct{}, deliveries <-chan *TaskMessage, due chan *TaskMessage) {
+	defer w.workWG.Done()
+	taskCtx := contextWithWorkerID(runCtx, workerID)
+	for {
+		select {
+		case <-wctx.Done():
+			return
+		case <-stop:
+			return
+		case taskMessage := <-due:
+			w.trackTask(taskMessage)
+			w.processTask(runCtx, taskCtx, taskMessage)
+			w.untrackTask(taskMessage.ID)
+			w.ackTask(runCtx, taskMessage)
+		case taskMessage, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			if wctx.Err() != nil {
+				// worker is stopping, return message to broker before it starts
+				w.returnTask(runCtx, taskMessage)
+				return
+			}
+			if eta, ok := taskMessage.etaTime(); ok && time.Now().Before(eta) {
+				w.holdTask(wctx, due, taskMessage, eta)
+				continue
+			}
+			w.trackTask(taskMessage)
+			w.processTask(runCtx, taskCtx, taskMessage)
+			w.untrackTask(taskMessage.ID)
+			w.ackTask(runCtx, taskMessage)
+		}
+	}
+}
+
+// holdTask hands message over to workers through due once its ETA is reached
+// Message still held when worker stops is sent back to broker.
+func (w *CeleryWorker) holdTask(wctx context.Context, due chan<- *TaskMessage, message *TaskMessage, eta time.Time) {
+	w.workWG.Add(1)
+	go func() {
+		defer w.workWG.Done()
+		timer := time.NewTimer(time.Until(eta))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			select {
+			case due <- message:
+				return
+			case <-wctx.Done():
+			}
+		case <-wctx.Done():
+		}
+		w.returnTask(wctx, message)
+	}()
+}
+
+// drain returns messages delivered after worker stopped back to broker
+// until broker closes deliveries channel
+func (w *CeleryWorker) drain(wctx, runCtx context.Context, deliveries <-chan *TaskMessage) {
+	defer w.workWG.Done()
+	<-wctx.Done()
+	for taskMessage := range deliveries {
+		w.returnTask(runCtx, taskMessage)
+	}
+}
+
+// processTask runs task within its time limits and pushes result to backend
+// Task context is derived from taskCtx which is cancelled when worker stops.
+func (w *CeleryWorker) processTask(ctx context.Context, taskCtx context.Context, message *TaskMessage) {
+	// result of task finishing while worker stops must still reach backend
+	ctx = context.WithoutCancel(ctx)
+
+	w.taskLock.RLock()
+	propagator, tracer, metrics, logger := w.propagator, w.tracer, w.metrics, w.logger
+	w.taskLock.RUnlock()
+	logger = taskLogger(taskCtx, logger, message)
+	if propagator != nil {
+		taskCtx = propagator.Extract(taskCtx, message.Headers)
+	}
+	taskCtx, span := startSpan(taskCtx, tracer, "consume", SpanKindConsumer, message)
+	defer span.End()
+	metrics.taskReceived(message)
+	started := time.Now()
+
+	// run task
+	resultMsg, err := w.runTaskWithSignals(taskCtx, message)
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		resultMsg, err = w.retryTask(ctx, message, retryErr)
+	}
+	if errors.Is(err, ErrTaskNotRegistered) {
+		switch policy := w.getUnregisteredTaskPolicy(); policy.Action {
+		case UnregisteredTaskRequeue:
+			metrics.taskProcessed(message, "RETRY", time.Since(started))
+			if policy.Delay <= 0 {
+				policy.Delay = defaultUnregisteredTaskDelay
 			}
-		}(i)
+			logger.Warn("task is not registered, requeueing message", "delay", policy.Delay)
+			w.requeueUnregisteredTask(ctx, message, policy.Delay)
+			return
+		case UnregisteredTaskReject:
+			// message is not dropped when there is no dead letter queue to keep it
+			if w.getDeadLetterQueue() == nil {
+				resultMsg, err = getErrorResultMessage(notRegisteredError(message)), nil
+			}
+		case UnregisteredTaskFail:
+			resultMsg, err = getErrorResultMessage(notRegisteredError(message)), nil
+		}
+	}
+	if err != nil {
+		metrics.taskProcessed(message, "FAILURE", time.Since(started))
+		span.RecordError(err)
+		logger.Error("failed to run task", "error", err)
+		if errors.Is(err, ErrTaskNotRegistered) {
+			w.deadLetterTask(ctx, message, DeadLetterUnregisteredTask, err)
+		}
+		return
+	}
+	if resultMsg != nil {
+		metrics.taskProcessed(message, resultMsg.Status, time.Since(started))
+	} else {
+		metrics.taskProcessed(message, "SUCCESS", time.Since(started))
+	}
+	if resultMsg == nil {
+		resultMsg = getResultMessage(nil)
+	}
+	defer releaseResultMessage(resultMsg)
+
+	// push result to backend
+	if err := w.backend.SetResult(ctx, message.ID, resultMsg); err != nil {
+		logger.Error("failed to push result", "error", err)
+	}
+}
+
+// retryTask sends task message back to broker to be retried
+// Task which exhausted its retries fails with MaxRetriesExceededError.
+func (w *CeleryWorker) retryTask(ctx context.Context, message *TaskMessage, retryErr *RetryError) (*ResultMessage, error) {
+	reason := retryErr.Err
+	if reason == nil {
+		reason = errors.New("retry requested")
+	}
+	if retryErr.MaxRetries > 0 && message.Retries >= retryErr.MaxRetries {
+		excInfo := &ExceptionInfo{
+			Type:    "MaxRetriesExceededError",
+			Message: []interface{}{fmt.Sprintf("Can't retry %s[%s] args:%v kwargs:%v", message.Task, message.ID, message.Args, message.Kwargs)},
+			Module:  "celery.exceptions",
+		}
+		w.notifyFailure(ctx, message, excInfo)
+		w.deadLetterTask(ctx, message, DeadLetterMaxRetriesExceeded, excInfo)
+		return getErrorResultMessage(excInfo), nil
+	}
+	if err := sendTaskMessage(ctx, w.broker, w.timeout, retryTaskMessage(message, retryErr.Countdown)); err != nil {
+		return nil, fmt.Errorf("failed to retry task %s: %w", message.ID, err)
+	}
+	w.taskLock.RLock()
+	handlers := w.signals.retry
+	w.taskLock.RUnlock()
+	for _, handler := range handlers {
+		w.dispatchSignal(ctx, message, "task_retry", func() { handler(ctx, message, retryErr) })
 	}
+	resultMsg := getErrorResultMessage(reason)
+	resultMsg.Status = "RETRY"
+	return resultMsg, nil
 }
 
 // StartWorker starts celery workers
@@ -81,8 +356,10 @@ func (w *CeleryWorker) StartWorker(ctx context.Context, timeout time.Duration) {
 }
 
 // StopWorker stops celery workers
+// Running tasks are cancelled and waited for.
 func (w *CeleryWorker) StopWorker() {
 	w.cancel()
+	w.runCancel()
 	w.workWG.Wait()
 }
 
@@ -91,11 +368,363 @@ func (w *CeleryWorker) StopWait() {
 	w.workWG.Wait()
 }
 
+// Shutdown gracefully stops celery workers
+// It stops fetching new messages and waits for running tasks until ctx is done.
+// Messages fetched but not yet started are sent back to broker.
+// Tasks still running when ctx is done are cancelled and abandoned,
+// in which case returned *ShutdownError describes them.
+func (w *CeleryWorker) Shutdown(ctx context.Context) error {
+	w.cancel()
+	done := make(chan struct{})
+	go func() {
+		w.workWG.Wait()
+		close(done)
+	}()
+
+	var abandoned []string
+	select {
+	case <-done:
+	case <-ctx.Done():
+		abandoned = w.inFlightTasks()
+	}
+	w.runCancel()
+
+	w.inFlightLock.Lock()
+	lost := w.lostTasks
+	w.lostTasks = nil
+	w.inFlightLock.Unlock()
+	if len(abandoned) == 0 && len(lost) == 0 {
+		return nil
+	}
+	return &ShutdownError{Abandoned: abandoned, Lost: lost}
+}
+
+// ShutdownOnSignal blocks until one of signals is received or ctx is done
+// and then gracefully shuts workers down allowing them given grace period.
+// SIGTERM and SIGINT are used if no signals are given.
+func (w *CeleryWorker) ShutdownOnSignal(ctx context.Context, grace time.Duration, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+	}
+	sigCtx, stop := signal.NotifyContext(ctx, signals...)
+	<-sigCtx.Done()
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), grace)
+	defer cancel()
+	return w.Shutdown(shutdownCtx)
+}
+
+// trackTask records task as in flight
+func (w *CeleryWorker) trackTask(message *TaskMessage) {
+	w.inFlightLock.Lock()
+	w.inFlight[message.ID] = formatTask(message)
+	w.inFlightLock.Unlock()
+}
+
+// untrackTask removes task from in flight tasks
+func (w *CeleryWorker) untrackTask(taskID string) {
+	w.inFlightLock.Lock()
+	delete(w.inFlight, taskID)
+	w.inFlightLock.Unlock()
+}
+
+// inFlightTasks returns sorted descriptions of tasks in flight
+func (w *CeleryWorker) inFlightTasks() []string {
+	w.inFlightLock.Lock()
+	defer w.inFlightLock.Unlock()
+	tasks := make([]string, 0, len(w.inFlight))
+	for _, task := range w.inFlight {
+		tasks = append(tasks, task)
+	}
+	sort.Strings(tasks)
+	return tasks
+}
+
+// requeueTask sends message which was not started back to broker
+// It is not interrupted by cancellation of ctx, as it runs while worker stops.
+func (w *CeleryWorker) requeueTask(ctx context.Context, message *TaskMessage) {
+	ctx = context.WithoutCancel(ctx)
+	if err := sendTaskMessage(ctx, w.broker, w.timeout, message); err != nil {
+		taskLogger(ctx, w.getLogger(), message).Error("failed to requeue task", "error", err)
+		w.inFlightLock.Lock()
+		w.lostTasks = append(w.lostTasks, formatTask(message))
+		w.inFlightLock.Unlock()
+	}
+}
+
+// returnTask returns delivered message to broker without processing it
+// Message is rejected with requeue if broker supports acknowledgements,
+// otherwise it is sent to broker again.
+func (w *CeleryWorker) returnTask(ctx context.Context, message *TaskMessage) {
+	broker, ok := w.broker.(AcknowledgingBroker)
+	if !ok {
+		w.requeueTask(ctx, message)
+		return
+	}
+	if err := broker.NackTaskMessage(context.WithoutCancel(ctx), message, true); err != nil {
+		taskLogger(ctx, w.getLogger(), message).Error("failed to requeue task", "error", err)
+		w.inFlightLock.Lock()
+		w.lostTasks = append(w.lostTasks, formatTask(message))
+		w.inFlightLock.Unlock()
+	}
+}
+
+// ackTask acknowledges processed message if broker supports acknowledgements
+func (w *CeleryWorker) ackTask(ctx context.Context, message *TaskMessage) {
+	broker, ok := w.broker.(AcknowledgingBroker)
+	if !ok {
+		return
+	}
+	if err := broker.AckTaskMessage(context.WithoutCancel(ctx), message); err != nil {
+		taskLogger(ctx, w.getLogger(), message).Warn("failed to acknowledge task", "error", err)
+	}
+}
+
+// formatTask describes task the way celery does, as name[id]
+func formatTask(message *TaskMessage) string {
+	return fmt.Sprintf("%s[%s]", message.Task, message.ID)
+}
+
+// ShutdownError describes tasks affected by shutdown deadline
+type ShutdownError struct {
+	Abandoned []string // tasks still running when deadline passed
+	Lost      []string // tasks fetched but failed to be sent back to broker
+}
+
+func (e *ShutdownError) Error() string {
+	var parts []string
+	if len(e.Abandoned) > 0 {
+		parts = append(parts, fmt.Sprintf("abandoned %d running tasks: %s", len(e.Abandoned), strings.Join(e.Abandoned, ", ")))
+	}
+	if len(e.Lost) > 0 {
+		parts = append(parts, fmt.Sprintf("failed to requeue %d tasks: %s", len(e.Lost), strings.Join(e.Lost, ", ")))
+	}
+	return "shutdown: " + strings.Join(parts, "; ")
+}
+
 // GetNumWorkers returns number of currently running workers
 func (w *CeleryWorker) GetNumWorkers() int {
+	w.poolLock.Lock()
+	defer w.poolLock.Unlock()
 	return w.numWorkers
 }
 
+// SetTimeLimits sets default soft and hard time limits for all tasks
+func (w *CeleryWorker) SetTimeLimits(soft, hard time.Duration) {
+	w.taskLock.Lock()
+	w.timeLimits = TimeLimits{Soft: soft, Hard: hard}
+	w.taskLock.Unlock()
+}
+
+// SetTaskTimeLimits sets soft and hard time limits for given task
+// overriding default time limits of the worker
+func (w *CeleryWorker) SetTaskTimeLimits(name string, soft, hard time.Duration) {
+	w.taskLock.Lock()
+	w.taskTimeLimits[name] = TimeLimits{Soft: soft, Hard: hard}
+	w.taskLock.Unlock()
+}
+
+// GetTimeLimits returns time limits applied to given task message
+// Limits sent with the message take precedence over task and default limits.
+func (w *CeleryWorker) GetTimeLimits(message *TaskMessage) TimeLimits {
+	w.taskLock.RLock()
+	limits, ok := w.taskTimeLimits[message.Task]
+	if !ok {
+		limits = w.timeLimits
+	}
+	w.taskLock.RUnlock()
+
+	// celery sends time limits as [hard, soft] in seconds
+	if len(message.TimeLimit) == 2 {
+		if hard := message.TimeLimit[0]; hard != nil {
+			limits.Hard = time.Duration(*hard * float64(time.Second))
+		}
+		if soft := message.TimeLimit[1]; soft != nil {
+			limits.Soft = time.Duration(*soft * float64(time.Second))
+		}
+	}
+	return limits
+}
+
+// GetAbandonedTasks returns number of tasks abandoned after exceeding hard time limit
+func (w *CeleryWorker) GetAbandonedTasks() int64 {
+	return w.abandonedTasks.Load()
+}
+
+// SetPanicHandler sets handler called when a task panics
+// Panics are recovered and reported as task failures regardless of the handler.
+func (w *CeleryWorker) SetPanicHandler(handler PanicHandler) {
+	w.taskLock.Lock()
+	w.panicHandler = handler
+	w.taskLock.Unlock()
+}
+
+// GetPanickedTasks returns number of tasks which panicked during execution
+func (w *CeleryWorker) GetPanickedTasks() int64 {
+	return w.panickedTasks.Load()
+}
+
+// SetPropagator sets propagator extracting context values from headers of task messages
+func (w *CeleryWorker) SetPropagator(propagator Propagator) {
+	w.taskLock.Lock()
+	w.propagator = propagator
+	w.taskLock.Unlock()
+}
+
+// SetTracer sets tracer creating consume and execute spans of tasks
+func (w *CeleryWorker) SetTracer(tracer Tracer) {
+	w.taskLock.Lock()
+	w.tracer = tracer
+	w.taskLock.Unlock()
+}
+
+// SetMetrics sets metrics collecting statistics of executed tasks
+func (w *CeleryWorker) SetMetrics(metrics *Metrics) {
+	w.taskLock.Lock()
+	w.metrics = metrics
+	w.taskLock.Unlock()
+}
+
+// SetDeadLetterQueue sets queue receiving messages of unregistered tasks
+// and tasks which exhausted their retries
+func (w *CeleryWorker) SetDeadLetterQueue(dlq DeadLetterQueue) {
+	w.taskLock.Lock()
+	w.deadLetters = dlq
+	w.taskLock.Unlock()
+}
+
+// SetUnregisteredTaskPolicy sets handling of messages of tasks not registered by worker
+func (w *CeleryWorker) SetUnregisteredTaskPolicy(policy UnregisteredTaskPolicy) {
+	w.taskLock.Lock()
+	w.unregistered = policy
+	w.taskLock.Unlock()
+}
+
+// getUnregisteredTaskPolicy returns handling of messages of unregistered tasks
+func (w *CeleryWorker) getUnregisteredTaskPolicy() UnregisteredTaskPolicy {
+	w.taskLock.RLock()
+	defer w.taskLock.RUnlock()
+	return w.unregistered
+}
+
+// requeueUnregisteredTask sends copy of message of unregistered task back to broker
+// ETA of the copy is delayed, therefore message stays with broker until it is due.
+func (w *CeleryWorker) requeueUnregisteredTask(ctx context.Context, message *TaskMessage, delay time.Duration) {
+	eta := time.Now().Add(delay).UTC().Format(time.RFC3339Nano)
+	requeueMessage := *message
+	requeueMessage.ETA = &eta
+	w.requeueTask(ctx, &requeueMessage)
+}
+
+// notRegisteredError returns celery NotRegistered exception of message task
+func notRegisteredError(message *TaskMessage) *ExceptionInfo {
+	return &ExceptionInfo{
+		Type:    "NotRegistered",
+		Message: []interface{}{message.Task},
+		Module:  "celery.exceptions",
+	}
+}
+
+// getDeadLetterQueue returns dead letter queue of the worker
+func (w *CeleryWorker) getDeadLetterQueue() DeadLetterQueue {
+	w.taskLock.RLock()
+	defer w.taskLock.RUnlock()
+	return w.deadLetters
+}
+
+// deadLetterTask stores task message in dead letter queue if it is configured
+func (w *CeleryWorker) deadLetterTask(ctx context.Context, message *TaskMessage, reason DeadLetterReason, err error) {
+	dlq := w.getDeadLetterQueue()
+	if dlq == nil {
+		return
+	}
+	logger := taskLogger(ctx, w.getLogger(), message)
+	deadLetter, encodeErr := newTaskDeadLetter(reason, err, message)
+	if encodeErr != nil {
+		logger.Error("failed to encode dead letter", "reason", reason, "error", encodeErr)
+		return
+	}
+	putDeadLetter(ctx, dlq, logger, deadLetter)
+}
+
+// SetLogger sets logger of the worker
+func (w *CeleryWorker) SetLogger(logger Logger) {
+	w.taskLock.Lock()
+	w.logger = logger
+	w.taskLock.Unlock()
+}
+
+// getLogger returns logger of the worker
+func (w *CeleryWorker) getLogger() Logger {
+	w.taskLock.RLock()
+	defer w.taskLock.RUnlock()
+	return loggerOrDefault(w.logger)
+}
+
+// getMetrics returns metrics of the worker, nil Metrics ignores all events
+func (w *CeleryWorker) getMetrics() *Metrics {
+	w.taskLock.RLock()
+	defer w.taskLock.RUnlock()
+	return w.metrics
+}
+
+// UseExecuteMiddleware appends middlewares wrapping execution of tasks
+func (w *CeleryWorker) UseExecuteMiddleware(middlewares ...ExecuteMiddleware) {
+	w.taskLock.Lock()
+	w.middlewares = append(w.middlewares, middlewares...)
+	w.taskLock.Unlock()
+}
+
+// OnTaskPrerun registers handler called before task is executed
+func (w *CeleryWorker) OnTaskPrerun(handler TaskSignalHandler) {
+	w.taskLock.Lock()
+	w.signals.prerun = append(w.signals.prerun, handler)
+	w.taskLock.Unlock()
+}
+
+// OnTaskPostrun registers handler called after task is executed
+func (w *CeleryWorker) OnTaskPostrun(handler TaskPostrunHandler) {
+	w.taskLock.Lock()
+	w.signals.postrun = append(w.signals.postrun, handler)
+	w.taskLock.Unlock()
+}
+
+// OnTaskFailure registers handler called when task fails
+func (w *CeleryWorker) OnTaskFailure(handler TaskErrorHandler) {
+	w.taskLock.Lock()
+	w.signals.failure = append(w.signals.failure, handler)
+	w.taskLock.Unlock()
+}
+
+// OnTaskRetry registers handler called when task is sent back to broker to be retried
+func (w *CeleryWorker) OnTaskRetry(handler TaskErrorHandler) {
+	w.taskLock.Lock()
+	w.signals.retry = append(w.signals.retry, handler)
+	w.taskLock.Unlock()
+}
+
+// notifyFailure calls task_failure handlers
+func (w *CeleryWorker) notifyFailure(ctx context.Context, message *TaskMessage, err error) {
+	w.taskLock.RLock()
+	handlers := w.signals.failure
+	w.taskLock.RUnlock()
+	for _, handler := range handlers {
+		w.dispatchSignal(ctx, message, "task_failure", func() { handler(ctx, message, err) })
+	}
+}
+
+// dispatchSignal calls signal handler recovering from its panic
+// Panic is logged and does not stop task processing or other handlers.
+func (w *CeleryWorker) dispatchSignal(ctx context.Context, message *TaskMessage, signal string, call func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			taskLogger(ctx, w.getLogger(), message).Error("signal handler panicked",
+				"signal", signal, "panic", recovered, "stack", string(debug.Stack()))
+		}
+	}()
+	call()
+}
+
 // Register registers tasks (functions)
 func (w *CeleryWorker) Register(name string, task interface{}) {
 	w.taskLock.Lock()
@@ -103,6 +732,13 @@ func (w *CeleryWorker) Register(name string, task interface{}) {
 	w.taskLock.Unlock()
 }
 
+// RegisterFactory registers task created by factory for every task message
+// Unlike CeleryTask registered using Register, task instances are not shared
+// between concurrently running workers.
+func (w *CeleryWorker) RegisterFactory(name string, factory CeleryTaskFactory) {
+	w.Register(name, &factoryTask{factory: factory})
+}
+
 // GetTask retrieves registered task
 func (w *CeleryWorker) GetTask(name string) interface{} {
 	w.taskLock.RLock()
@@ -117,59 +753,254 @@ func (w *CeleryWorker) GetTask(name string) interface{} {
 
 // RunTask runs celery task
 func (w *CeleryWorker) RunTask(message *TaskMessage) (*ResultMessage, error) {
+	return w.runTaskWithSignals(context.Background(), message)
+}
+
+// runTaskWithSignals runs celery task within its time limits
+// and notifies task_prerun, task_postrun and task_failure handlers
+func (w *CeleryWorker) runTaskWithSignals(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
+	ctx = ContextWithTaskRequest(ctx, newTaskRequest(message))
+	w.taskLock.RLock()
+	prerun, postrun, tracer := w.signals.prerun, w.signals.postrun, w.tracer
+	w.taskLock.RUnlock()
+
+	ctx, span := startSpan(ctx, tracer, "execute", SpanKindInternal, message)
+	defer span.End()
+
+	for _, handler := range prerun {
+		w.dispatchSignal(ctx, message, "task_prerun", func() { handler(ctx, message) })
+	}
+	resultMsg, err := w.runTaskWithTimeLimits(ctx, message)
+	var retryErr *RetryError
+	switch {
+	case errors.As(err, &retryErr):
+		// retried tasks are reported by processTask
+		span.RecordError(err)
+	case err != nil:
+		span.RecordError(err)
+		w.notifyFailure(ctx, message, err)
+	case resultMsg != nil && resultMsg.Status == "FAILURE":
+		span.RecordError(resultError(resultMsg))
+		w.notifyFailure(ctx, message, resultError(resultMsg))
+	}
+	for _, handler := range postrun {
+		w.dispatchSignal(ctx, message, "task_postrun", func() { handler(ctx, message, resultMsg) })
+	}
+	return resultMsg, err
+}
+
+// runTaskWithTimeLimits runs celery task enforcing its soft and hard time limits
+// Task exceeding hard time limit is abandoned and TimeLimitExceeded failure is returned.
+func (w *CeleryWorker) runTaskWithTimeLimits(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
+	limits := w.GetTimeLimits(message)
+
+	tctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if limits.Soft > 0 {
+		tctx, cancel = context.WithTimeoutCause(tctx, limits.Soft, ErrSoftTimeLimitExceeded)
+		defer cancel()
+	}
+	if limits.Hard <= 0 {
+		return w.runTask(tctx, message)
+	}
+
+	type taskOutcome struct {
+		result *ResultMessage
+		err    error
+	}
+	done := make(chan taskOutcome, 1)
+	go func() {
+		res, err := w.runTask(tctx, message)
+		done <- taskOutcome{res, err}
+	}()
+
+	hardTimer := time.NewTimer(limits.Hard)
+	defer hardTimer.Stop()
+	select {
+	case outcome := <-done:
+		return outcome.result, outcome.err
+	case <-hardTimer.C:
+		w.abandonedTasks.Add(1)
+		w.getMetrics().taskAbandoned(message)
+		taskLogger(ctx, w.getLogger(), message).Warn("task exceeded hard time limit and was abandoned", "hard_limit", limits.Hard)
+		return getFailureResultMessage("TimeLimitExceeded", "billiard.exceptions", limits.Hard.Seconds()), nil
+	}
+}
+
+// runTask runs celery task wrapped by execute middlewares
+// recovering from panics
+func (w *CeleryWorker) runTask(ctx context.Context, message *TaskMessage) (resultMsg *ResultMessage, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			stack := debug.Stack()
+			w.panickedTasks.Add(1)
+			w.getMetrics().taskPanicked(message)
+			taskLogger(ctx, w.getLogger(), message).Error("task panicked", "panic", recovered, "stack", string(stack))
+			w.taskLock.RLock()
+			handler := w.panicHandler
+			w.taskLock.RUnlock()
+			if handler != nil {
+				handler(message, recovered, stack)
+			}
+			resultMsg = getFailureResultMessage("RuntimeError", "builtins", fmt.Sprintf("panic: %v", recovered))
+			resultMsg.Traceback = string(stack)
+			err = nil
+		}
+	}()
+
+	w.taskLock.RLock()
+	execute := chainExecute(w.executeTask, w.middlewares)
+	w.taskLock.RUnlock()
+	return execute(ctx, message)
+}
+
+// executeTask executes registered task matching the message
+// ensuring its result can be encoded
+func (w *CeleryWorker) executeTask(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
+	resultMsg, err := w.executeRegisteredTask(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	return ensureEncodable(resultMsg), nil
+}
+
+// executeRegisteredTask executes registered task matching the message
+func (w *CeleryWorker) executeRegisteredTask(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
 
 	// get task
 	task := w.GetTask(message.Task)
 	if task == nil {
-		return nil, fmt.Errorf("task %s is not registered", message.Task)
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotRegistered, message.Task)
+	}
+
+	// run task directly from message if supported
+	if msgTask, ok := task.(messageTask); ok {
+		return msgTask.runTaskMessage(ctx, message)
 	}
 
 	// convert to task interface
-	taskInterface, ok := task.(CeleryTask)
-	if ok {
+	switch task.(type) {
+	case CeleryTaskWithContext, CeleryTask:
+		return runCeleryTask(ctx, task, message)
+	}
+
+	// use reflection to execute function ptr
+	taskFunc := reflect.ValueOf(task)
+	return runTaskFunc(ctx, &taskFunc, message)
+}
+
+// runCeleryTask parses kwargs and runs task implementing CeleryTask or CeleryTaskWithContext
+// Kwargs which cannot be parsed are reported as TypeError failure, errors of the task
+// as its failure, except for RetryError which is returned to retry the task.
+func runCeleryTask(ctx context.Context, task interface{}, message *TaskMessage) (*ResultMessage, error) {
+	var val interface{}
+	var err error
+	switch taskInterface := task.(type) {
+	case CeleryTaskWithContext:
 		if err := taskInterface.ParseKwargs(message.Kwargs); err != nil {
-			return nil, err
+			return getFailureResultMessage("TypeError", "builtins", fmt.Sprintf("task %s: %v", message.Task, err)), nil
 		}
-		val, err := taskInterface.RunTask()
-		if err != nil {
+		val, err = taskInterface.RunTaskWithContext(ctx)
+	case CeleryTask:
+		if err := taskInterface.ParseKwargs(message.Kwargs); err != nil {
+			return getFailureResultMessage("TypeError", "builtins", fmt.Sprintf("task %s: %v", message.Task, err)), nil
+		}
+		val, err = taskInterface.RunTask()
+	default:
+		return nil, fmt.Errorf("task %s does not implement CeleryTask", message.Task)
+	}
+	if err != nil {
+		var retryErr *RetryError
+		if errors.As(err, &retryErr) {
 			return nil, err
 		}
-		return getResultMessage(val), err
+		return getErrorResultMessage(err), nil
 	}
+	return getResultMessage(val), nil
+}
 
-	// use reflection to execute function ptr
-	taskFunc := reflect.ValueOf(task)
-	return runTaskFunc(&taskFunc, message)
+// factoryTask creates new task instance for every task message
+type factoryTask struct {
+	factory CeleryTaskFactory
+}
+
+func (t *factoryTask) runTaskMessage(ctx context.Context, message *TaskMessage) (*ResultMessage, error) {
+	return runCeleryTask(ctx, t.factory(), message)
 }
 
-func runTaskFunc(taskFunc *reflect.Value, message *TaskMessage) (*ResultMessage, error) {
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func runTaskFunc(ctx context.Context, taskFunc *reflect.Value, message *TaskMessage) (*ResultMessage, error) {
+	funcType := taskFunc.Type()
+
+	// functions accepting context.Context as first parameter receive task context
+	numIn := funcType.NumIn()
+	in := make([]reflect.Value, 0, numIn)
+	if numIn > 0 && funcType.In(0) == contextType {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	offset := len(in)
 
 	// check number of arguments
-	numArgs := taskFunc.Type().NumIn()
+	numArgs := numIn - offset
 	messageNumArgs := len(message.Args)
-	if numArgs != messageNumArgs {
-		return nil, fmt.Errorf("Number of task arguments %d does not match number of message arguments %d", numArgs, messageNumArgs)
+	if funcType.IsVariadic() {
+		if messageNumArgs < numArgs-1 {
+			err := fmt.Errorf("task %s takes at least %d arguments but %d were given", message.Task, numArgs-1, messageNumArgs)
+			return getFailureResultMessage("TypeError", "builtins", err.Error()), nil
+		}
+	} else if numArgs != messageNumArgs {
+		err := fmt.Errorf("Number of task arguments %d does not match number of message arguments %d", numArgs, messageNumArgs)
+		return getFailureResultMessage("TypeError", "builtins", err.Error()), nil
 	}
 
 	// construct arguments
-	in := make([]reflect.Value, messageNumArgs)
+	// json decoded values are converted to types of function parameters
 	for i, arg := range message.Args {
-		origType := taskFunc.Type().In(i).Kind()
-		msgType := reflect.TypeOf(arg).Kind()
-		// special case - convert float64 to int if applicable
-		// this is due to json limitation where all numbers are converted to float64
-		if origType == reflect.Int && msgType == reflect.Float64 {
-			arg = int(arg.(float64))
+		var paramType reflect.Type
+		if funcType.IsVariadic() && offset+i >= numIn-1 {
+			paramType = funcType.In(numIn - 1).Elem()
+		} else {
+			paramType = funcType.In(offset + i)
 		}
-
-		in[i] = reflect.ValueOf(arg)
+		val, err := ConvertArgument(arg, paramType)
+		if err != nil {
+			err = fmt.Errorf("task %s argument %d: %v", message.Task, i, err)
+			return getFailureResultMessage("TypeError", "builtins", err.Error()), nil
+		}
+		in = append(in, val)
 	}
 
 	// call method
 	res := taskFunc.Call(in)
-	if len(res) == 0 {
-		return nil, nil
+
+	// trailing error return value reports failure of the task
+	if numOut := len(res); numOut > 0 && funcType.Out(numOut-1) == errorType {
+		if errVal := res[numOut-1]; !errVal.IsNil() {
+			err := errVal.Interface().(error)
+			var retryErr *RetryError
+			if errors.As(err, &retryErr) {
+				return nil, err
+			}
+			return getErrorResultMessage(err), nil
+		}
+		res = res[:numOut-1]
 	}
 
-	return getReflectionResultMessage(&res[0]), nil
+	switch len(res) {
+	case 0:
+		return getResultMessage(nil), nil
+	case 1:
+		return getReflectionResultMessage(&res[0]), nil
+	default:
+		// multiple return values are returned as list like python tuples
+		vals := make([]interface{}, len(res))
+		for i := range res {
+			vals[i] = GetRealValue(&res[i])
+		}
+		return getResultMessage(vals), nil
+	}
 }
@@ -6,8 +6,13 @@ package gocelery
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -177,3 +182,876 @@ func TestWorkerStartStop(t *testing.T) {
 		}()
 	}
 }
+
+// TestWorkerHardTimeLimit ensures task exceeding hard time limit is abandoned
+// and reported as TimeLimitExceeded failure
+func TestWorkerHardTimeLimit(t *testing.T) {
+	testCases := []struct {
+		name      string
+		taskFunc  interface{}
+		hardLimit time.Duration
+		status    string
+		abandoned int64
+	}{
+		{
+			name:      "task finishing within hard time limit",
+			taskFunc:  add,
+			hardLimit: time.Second,
+			status:    "SUCCESS",
+			abandoned: 0,
+		},
+		{
+			name: "task exceeding hard time limit",
+			taskFunc: func(a, b int) int {
+				time.Sleep(time.Second)
+				return a + b
+			},
+			hardLimit: 50 * time.Millisecond,
+			status:    "FAILURE",
+			abandoned: 1,
+		},
+	}
+	for _, tc := range testCases {
+		celeryWorker := NewCeleryWorker(nil, nil, 1)
+		taskName := stringutil.UUID().String()
+		celeryWorker.Register(taskName, tc.taskFunc)
+		celeryWorker.SetTaskTimeLimits(taskName, 0, tc.hardLimit)
+		taskMessage := &TaskMessage{
+			ID:   stringutil.UUID().String(),
+			Task: taskName,
+			Args: []interface{}{1, 2},
+		}
+		resultMsg, err := celeryWorker.RunTask(taskMessage)
+		if err != nil {
+			t.Errorf("test '%s': failed to run celery task %v: %v", tc.name, taskMessage, err)
+			continue
+		}
+		if resultMsg.Status != tc.status {
+			t.Errorf("test '%s': expected status %s but received %s", tc.name, tc.status, resultMsg.Status)
+		}
+		if tc.status == "FAILURE" {
+			excInfo, ok := resultMsg.Result.(*ExceptionInfo)
+			if !ok || excInfo.Type != "TimeLimitExceeded" {
+				t.Errorf("test '%s': expected TimeLimitExceeded but received %+v", tc.name, resultMsg.Result)
+			}
+		}
+		if abandoned := celeryWorker.GetAbandonedTasks(); abandoned != tc.abandoned {
+			t.Errorf("test '%s': expected %d abandoned tasks but received %d", tc.name, tc.abandoned, abandoned)
+		}
+	}
+}
+
+// TestWorkerTimeLimitsPrecedence ensures message time limits override task and default time limits
+func TestWorkerTimeLimitsPrecedence(t *testing.T) {
+	soft, hard := 3.0, 4.0
+	celeryWorker := NewCeleryWorker(nil, nil, 1)
+	celeryWorker.SetTimeLimits(time.Second, 2*time.Second)
+	celeryWorker.SetTaskTimeLimits("limited", 5*time.Second, 6*time.Second)
+	testCases := []struct {
+		name     string
+		message  *TaskMessage
+		expected TimeLimits
+	}{
+		{
+			name:     "default time limits",
+			message:  &TaskMessage{Task: "unlimited"},
+			expected: TimeLimits{Soft: time.Second, Hard: 2 * time.Second},
+		},
+		{
+			name:     "task time limits",
+			message:  &TaskMessage{Task: "limited"},
+			expected: TimeLimits{Soft: 5 * time.Second, Hard: 6 * time.Second},
+		},
+		{
+			name:     "message time limits",
+			message:  &TaskMessage{Task: "limited", TimeLimit: []*float64{&hard, &soft}},
+			expected: TimeLimits{Soft: 3 * time.Second, Hard: 4 * time.Second},
+		},
+		{
+			name:     "partial message time limits",
+			message:  &TaskMessage{Task: "limited", TimeLimit: []*float64{&hard, nil}},
+			expected: TimeLimits{Soft: 5 * time.Second, Hard: 4 * time.Second},
+		},
+	}
+	for _, tc := range testCases {
+		limits := celeryWorker.GetTimeLimits(tc.message)
+		if limits != tc.expected {
+			t.Errorf("test '%s': expected time limits %+v but received %+v", tc.name, tc.expected, limits)
+		}
+	}
+}
+
+// requestTask returns ID of executing task from its context
+func requestTask(ctx context.Context, a int) (string, error) {
+	req, ok := TaskRequestFromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("task request missing in context")
+	}
+	return fmt.Sprintf("%s:%d:%s", req.ID, a, req.ParentID), nil
+}
+
+// softLimitTask waits for its context to be cancelled by soft time limit
+type softLimitTask struct{}
+
+func (s *softLimitTask) ParseKwargs(kwargs map[string]interface{}) error {
+	return nil
+}
+
+func (s *softLimitTask) RunTaskWithContext(ctx context.Context) (interface{}, error) {
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx).Error(), nil
+	case <-time.After(time.Second):
+		return "", fmt.Errorf("context was not cancelled")
+	}
+}
+
+// TestWorkerRunTaskWithContext ensures tasks receive task context with request metadata
+func TestWorkerRunTaskWithContext(t *testing.T) {
+	testCases := []struct {
+		name           string
+		registeredTask interface{}
+		args           []interface{}
+		softLimit      time.Duration
+		expected       func(message *TaskMessage) string
+	}{
+		{
+			name:           "function with context parameter",
+			registeredTask: requestTask,
+			args:           []interface{}{float64(5)},
+			expected: func(message *TaskMessage) string {
+				return message.ID + ":5:parent"
+			},
+		},
+		{
+			name:           "task with context cancelled by soft time limit",
+			registeredTask: &softLimitTask{},
+			softLimit:      50 * time.Millisecond,
+			expected: func(message *TaskMessage) string {
+				return ErrSoftTimeLimitExceeded.Error()
+			},
+		},
+	}
+	for _, tc := range testCases {
+		celeryWorker := NewCeleryWorker(nil, nil, 1)
+		taskName := stringutil.UUID().String()
+		celeryWorker.Register(taskName, tc.registeredTask)
+		celeryWorker.SetTimeLimits(tc.softLimit, 0)
+		taskMessage := &TaskMessage{
+			ID:      stringutil.UUID().String(),
+			Task:    taskName,
+			Args:    tc.args,
+			Headers: map[string]interface{}{"parent_id": "parent"},
+		}
+		resultMsg, err := celeryWorker.RunTask(taskMessage)
+		if err != nil {
+			t.Errorf("test '%s': failed to run celery task %v: %v", tc.name, taskMessage, err)
+			continue
+		}
+		if expected := tc.expected(taskMessage); resultMsg.Result != expected {
+			t.Errorf("test '%s': expected result %v but received %v", tc.name, expected, resultMsg.Result)
+		}
+	}
+}
+
+// sumAll is variadic test task method
+func sumAll(base int64, values ...uint) int64 {
+	for _, v := range values {
+		base += int64(v)
+	}
+	return base
+}
+
+// TestWorkerRunTaskArguments tests argument conversion of reflected task functions
+func TestWorkerRunTaskArguments(t *testing.T) {
+	testCases := []struct {
+		name           string
+		registeredTask interface{}
+		args           []interface{}
+		status         string
+		expected       interface{}
+	}{
+		{
+			name:           "variadic function without variadic arguments",
+			registeredTask: sumAll,
+			args:           []interface{}{float64(1)},
+			status:         "SUCCESS",
+			expected:       int64(1),
+		},
+		{
+			name:           "variadic function with variadic arguments",
+			registeredTask: sumAll,
+			args:           []interface{}{float64(1), float64(2), float64(3)},
+			status:         "SUCCESS",
+			expected:       int64(6),
+		},
+		{
+			name:           "variadic function with mismatching argument",
+			registeredTask: sumAll,
+			args:           []interface{}{float64(1), float64(-2)},
+			status:         "FAILURE",
+		},
+		{
+			name:           "function with mismatching argument",
+			registeredTask: add,
+			args:           []interface{}{"a", float64(2)},
+			status:         "FAILURE",
+		},
+		{
+			name:           "function with missing argument",
+			registeredTask: add,
+			args:           []interface{}{float64(2)},
+			status:         "FAILURE",
+		},
+	}
+	for _, tc := range testCases {
+		celeryWorker := NewCeleryWorker(nil, nil, 1)
+		taskName := stringutil.UUID().String()
+		celeryWorker.Register(taskName, tc.registeredTask)
+		taskMessage := &TaskMessage{
+			ID:   stringutil.UUID().String(),
+			Task: taskName,
+			Args: tc.args,
+		}
+		resultMsg, err := celeryWorker.RunTask(taskMessage)
+		if err != nil {
+			t.Errorf("test '%s': failed to run celery task %v: %v", tc.name, taskMessage, err)
+			continue
+		}
+		if resultMsg.Status != tc.status {
+			t.Errorf("test '%s': expected status %s but received %s: %+v", tc.name, tc.status, resultMsg.Status, resultMsg.Result)
+			continue
+		}
+		if tc.status == "FAILURE" {
+			if excInfo, ok := resultMsg.Result.(*ExceptionInfo); !ok || excInfo.Type != "TypeError" {
+				t.Errorf("test '%s': expected TypeError but received %+v", tc.name, resultMsg.Result)
+			}
+			continue
+		}
+		if resultMsg.Result != tc.expected {
+			t.Errorf("test '%s': expected result %v but received %v", tc.name, tc.expected, resultMsg.Result)
+		}
+	}
+}
+
+// divide is test task method returning error
+func divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return a / b, nil
+}
+
+// divmod is test task method returning multiple values
+func divmod(a, b int) (int, int, error) {
+	if b == 0 {
+		return 0, 0, &ExceptionInfo{Type: "ZeroDivisionError", Message: []interface{}{"integer division or modulo by zero"}, Module: "builtins"}
+	}
+	return a / b, a % b, nil
+}
+
+// TestWorkerRunTaskReturnValues tests results of functions returning errors and multiple values
+func TestWorkerRunTaskReturnValues(t *testing.T) {
+	testCases := []struct {
+		name           string
+		registeredTask interface{}
+		args           []interface{}
+		status         string
+		expected       interface{}
+	}{
+		{
+			name:           "function returning value and nil error",
+			registeredTask: divide,
+			args:           []interface{}{float64(7), float64(2)},
+			status:         "SUCCESS",
+			expected:       int64(3),
+		},
+		{
+			name:           "function returning error",
+			registeredTask: divide,
+			args:           []interface{}{float64(7), float64(0)},
+			status:         "FAILURE",
+			expected:       &ExceptionInfo{Type: "Exception", Message: []interface{}{"division by zero"}, Module: "builtins"},
+		},
+		{
+			name:           "function returning multiple values",
+			registeredTask: divmod,
+			args:           []interface{}{float64(7), float64(2)},
+			status:         "SUCCESS",
+			expected:       []interface{}{int64(3), int64(1)},
+		},
+		{
+			name:           "function returning exception info",
+			registeredTask: divmod,
+			args:           []interface{}{float64(7), float64(0)},
+			status:         "FAILURE",
+			expected:       &ExceptionInfo{Type: "ZeroDivisionError", Message: []interface{}{"integer division or modulo by zero"}, Module: "builtins"},
+		},
+		{
+			name:           "function returning nothing",
+			registeredTask: func(a int) {},
+			args:           []interface{}{float64(1)},
+			status:         "SUCCESS",
+			expected:       nil,
+		},
+	}
+	for _, tc := range testCases {
+		celeryWorker := NewCeleryWorker(nil, nil, 1)
+		taskName := stringutil.UUID().String()
+		celeryWorker.Register(taskName, tc.registeredTask)
+		taskMessage := &TaskMessage{
+			ID:   stringutil.UUID().String(),
+			Task: taskName,
+			Args: tc.args,
+		}
+		resultMsg, err := celeryWorker.RunTask(taskMessage)
+		if err != nil {
+			t.Errorf("test '%s': failed to run celery task %v: %v", tc.name, taskMessage, err)
+			continue
+		}
+		if resultMsg.Status != tc.status {
+			t.Errorf("test '%s': expected status %s but received %s", tc.name, tc.status, resultMsg.Status)
+		}
+		if !reflect.DeepEqual(resultMsg.Result, tc.expected) {
+			t.Errorf("test '%s': expected result %+v but received %+v", tc.name, tc.expected, resultMsg.Result)
+		}
+	}
+}
+
+// TestWorkerRunTaskUnencodableResult ensures results which cannot be encoded are reported as failure
+func TestWorkerRunTaskUnencodableResult(t *testing.T) {
+	celeryWorker := NewCeleryWorker(nil, nil, 1)
+	taskName := stringutil.UUID().String()
+	celeryWorker.Register(taskName, func() chan int {
+		return make(chan int)
+	})
+	resultMsg, err := celeryWorker.RunTask(&TaskMessage{
+		ID:   stringutil.UUID().String(),
+		Task: taskName,
+		Args: []interface{}{},
+	})
+	if err != nil {
+		t.Fatalf("failed to run celery task: %v", err)
+	}
+	excInfo, ok := resultMsg.Result.(*ExceptionInfo)
+	if resultMsg.Status != "FAILURE" || !ok || excInfo.Type != "EncodeError" {
+		t.Errorf("expected EncodeError failure but received %s %+v", resultMsg.Status, resultMsg.Result)
+	}
+}
+
+// echoTask returns its kwarg after a short delay
+// exposing shared state if the same instance runs concurrently
+type echoTask struct {
+	value string
+}
+
+func (e *echoTask) ParseKwargs(kwargs map[string]interface{}) error {
+	value, ok := kwargs["value"].(string)
+	if !ok {
+		return fmt.Errorf("malformed kwarg value")
+	}
+	e.value = value
+	return nil
+}
+
+func (e *echoTask) RunTask() (interface{}, error) {
+	time.Sleep(time.Millisecond)
+	return e.value, nil
+}
+
+// TestWorkerRegisterFactoryConcurrent ensures concurrently running tasks
+// registered with factory do not share state
+func TestWorkerRegisterFactoryConcurrent(t *testing.T) {
+	celeryWorker := NewCeleryWorker(nil, nil, 1)
+	taskName := stringutil.UUID().String()
+	celeryWorker.RegisterFactory(taskName, func() CeleryTask {
+		return &echoTask{}
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			resultMsg, err := celeryWorker.RunTask(&TaskMessage{
+				ID:     stringutil.UUID().String(),
+				Task:   taskName,
+				Kwargs: map[string]interface{}{"value": value},
+			})
+			if err != nil {
+				t.Errorf("failed to run task %s: %v", value, err)
+				return
+			}
+			if resultMsg.Result != value {
+				t.Errorf("task %s returned result of another task %v", value, resultMsg.Result)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+}
+
+// failingTask fails to parse kwargs or to run depending on its kwargs
+type failingTask struct {
+	err error
+}
+
+func (f *failingTask) ParseKwargs(kwargs map[string]interface{}) error {
+	if _, ok := kwargs["invalid"]; ok {
+		return fmt.Errorf("invalid kwarg")
+	}
+	if reason, ok := kwargs["error"].(string); ok {
+		f.err = &ExceptionInfo{Type: reason, Module: "builtins"}
+	}
+	return nil
+}
+
+func (f *failingTask) RunTask() (interface{}, error) {
+	return nil, f.err
+}
+
+// TestWorkerCeleryTaskFailure ensures errors of CeleryTask are stored as FAILURE results
+func TestWorkerCeleryTaskFailure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		register func(w *CeleryWorker, name string)
+		kwargs   map[string]interface{}
+		excType  string
+	}{
+		{
+			name:     "invalid kwargs",
+			register: func(w *CeleryWorker, name string) { w.Register(name, &failingTask{}) },
+			kwargs:   map[string]interface{}{"invalid": true},
+			excType:  "TypeError",
+		},
+		{
+			name:     "task error",
+			register: func(w *CeleryWorker, name string) { w.Register(name, &failingTask{}) },
+			kwargs:   map[string]interface{}{"error": "ValueError"},
+			excType:  "ValueError",
+		},
+		{
+			name: "factory task error",
+			register: func(w *CeleryWorker, name string) {
+				w.RegisterFactory(name, func() CeleryTask { return &failingTask{} })
+			},
+			kwargs:  map[string]interface{}{"error": "KeyError"},
+			excType: "KeyError",
+		},
+	}
+	for _, tc := range testCases {
+		backend := NewMemoryCeleryBackend()
+		celeryWorker := NewCeleryWorker(nil, backend, 1)
+		celeryWorker.SetLogger(NopLogger())
+		taskName := stringutil.UUID().String()
+		tc.register(celeryWorker, taskName)
+		taskMessage := &TaskMessage{
+			ID:     stringutil.UUID().String(),
+			Task:   taskName,
+			Args:   []interface{}{},
+			Kwargs: tc.kwargs,
+		}
+		ctx := context.Background()
+		celeryWorker.processTask(ctx, ctx, taskMessage)
+
+		resultMsg, err := backend.GetResult(ctx, taskMessage.ID)
+		if err != nil || resultMsg.Status != "FAILURE" {
+			t.Errorf("test '%s': expected FAILURE result, got %+v (%v)", tc.name, resultMsg, err)
+			continue
+		}
+		if excInfo, ok := resultMsg.Result.(map[string]interface{}); !ok || excInfo["exc_type"] != tc.excType {
+			t.Errorf("test '%s': expected %s, got %v", tc.name, tc.excType, resultMsg.Result)
+		}
+	}
+}
+
+// TestWorkerTaskPanic ensures panicking task is reported as failure with stack trace
+func TestWorkerTaskPanic(t *testing.T) {
+	backend := NewMemoryCeleryBackend()
+	celeryWorker := NewCeleryWorker(nil, backend, 1)
+	taskName := stringutil.UUID().String()
+	celeryWorker.Register(taskName, func(a int) int {
+		var m map[int]int
+		m[a] = a
+		return a
+	})
+	var handled []string
+	celeryWorker.SetPanicHandler(func(message *TaskMessage, recovered interface{}, stack []byte) {
+		handled = append(handled, message.ID)
+	})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		taskMessage := &TaskMessage{
+			ID:   stringutil.UUID().String(),
+			Task: taskName,
+			Args: []interface{}{float64(i)},
+		}
+		celeryWorker.processTask(ctx, ctx, taskMessage)
+		resultMsg, err := backend.GetResult(ctx, taskMessage.ID)
+		if err != nil {
+			t.Errorf("failed to get result of panicking task: %v", err)
+			continue
+		}
+		if resultMsg.Status != "FAILURE" {
+			t.Errorf("expected FAILURE status but received %s", resultMsg.Status)
+		}
+		if traceback, ok := resultMsg.Traceback.(string); !ok || !strings.Contains(traceback, "TestWorkerTaskPanic") {
+			t.Errorf("expected stack trace of panicking task but received %v", resultMsg.Traceback)
+		}
+	}
+	if panicked := celeryWorker.GetPanickedTasks(); panicked != 2 {
+		t.Errorf("expected 2 panicked tasks but received %d", panicked)
+	}
+	if len(handled) != 2 {
+		t.Errorf("expected panic handler to be called twice but was called %d times", len(handled))
+	}
+}
+
+// queueBroker keeps task messages in memory
+// Message set as late is returned after blocking until fetching context is cancelled.
+type queueBroker struct {
+	sync.Mutex
+	messages []*TaskMessage
+	late     *TaskMessage
+}
+
+func (b *queueBroker) SendCeleryMessage(ctx context.Context, timeout time.Duration, message *CeleryMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	taskMessage := message.GetTaskMessage(ctx, timeout)
+	if taskMessage == nil {
+		return fmt.Errorf("failed to decode task message")
+	}
+	b.Lock()
+	b.messages = append(b.messages, taskMessage)
+	b.Unlock()
+	return nil
+}
+
+func (b *queueBroker) GetTaskMessage(ctx context.Context, timeout time.Duration) (*TaskMessage, error) {
+	b.Lock()
+	if len(b.messages) > 0 {
+		message := b.messages[0]
+		b.messages = b.messages[1:]
+		b.Unlock()
+		return message, nil
+	}
+	message := b.late
+	b.late = nil
+	b.Unlock()
+	if message != nil {
+		<-ctx.Done()
+		return message, nil
+	}
+	time.Sleep(time.Millisecond)
+	return nil, fmt.Errorf("queue is empty")
+}
+
+func (b *queueBroker) QueueDepth(ctx context.Context, queue string) (int64, error) {
+	b.Lock()
+	defer b.Unlock()
+	return int64(len(b.messages)), nil
+}
+
+func (b *queueBroker) queued() []string {
+	b.Lock()
+	defer b.Unlock()
+	var ids []string
+	for _, message := range b.messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+// TestWorkerShutdown tests draining in-flight tasks on graceful shutdown
+func TestWorkerShutdown(t *testing.T) {
+	testCases := []struct {
+		name      string
+		duration  time.Duration
+		deadline  time.Duration
+		abandoned bool
+	}{
+		{
+			name:     "task finishing before deadline",
+			duration: 50 * time.Millisecond,
+			deadline: 5 * time.Second,
+		},
+		{
+			name:      "task running past deadline",
+			duration:  5 * time.Second,
+			deadline:  50 * time.Millisecond,
+			abandoned: true,
+		},
+	}
+	for _, tc := range testCases {
+		broker := &queueBroker{}
+		backend := NewMemoryCeleryBackend()
+		cli, _ := NewCeleryClient(broker, backend, 1)
+		started := make(chan struct{})
+		cancelled := make(chan struct{})
+		cli.Register("sleep", func(ctx context.Context) string {
+			close(started)
+			select {
+			case <-time.After(tc.duration):
+				return "done"
+			case <-ctx.Done():
+				close(cancelled)
+				return "cancelled"
+			}
+		})
+		ctx := context.Background()
+		asyncResult, err := cli.Delay(ctx, TIMEOUT, "sleep")
+		if err != nil {
+			t.Errorf("test '%s': failed to send task: %v", tc.name, err)
+			continue
+		}
+		cli.StartWorker(ctx, TIMEOUT)
+		<-started
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, tc.deadline)
+		err = cli.Shutdown(shutdownCtx)
+		cancel()
+		if !tc.abandoned {
+			if err != nil {
+				t.Errorf("test '%s': unexpected shutdown error: %v", tc.name, err)
+			}
+			res, err := asyncResult.Get(ctx, TIMEOUT)
+			if err != nil || res != "done" {
+				t.Errorf("test '%s': expected drained task result, got %v (%v)", tc.name, res, err)
+			}
+			continue
+		}
+		var shutdownErr *ShutdownError
+		if !errors.As(err, &shutdownErr) {
+			t.Errorf("test '%s': expected ShutdownError, got %v", tc.name, err)
+			continue
+		}
+		expected := []string{"sleep[" + asyncResult.TaskID() + "]"}
+		if !reflect.DeepEqual(shutdownErr.Abandoned, expected) {
+			t.Errorf("test '%s': expected abandoned %v, got %v", tc.name, expected, shutdownErr.Abandoned)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Errorf("test '%s': abandoned task was not cancelled", tc.name)
+		}
+	}
+}
+
+// TestWorkerRetryCountdown tests that retried task is not executed before its countdown
+func TestWorkerRetryCountdown(t *testing.T) {
+	broker := &queueBroker{}
+	backend := NewMemoryCeleryBackend()
+	worker := NewCeleryWorker(broker, backend, 1)
+	executions := make(chan time.Time, 2)
+	worker.Register("flaky", func(ctx context.Context) error {
+		executions <- time.Now()
+		if req, _ := TaskRequestFromContext(ctx); req.Retries == 0 {
+			return Retry(fmt.Errorf("temporarily unavailable"), 200*time.Millisecond, 0)
+		}
+		return nil
+	})
+	message := getTaskMessage(context.Background(), "flaky")
+	if err := sendTaskMessage(context.Background(), broker, TIMEOUT, message); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	worker.StartWorker(context.Background(), TIMEOUT)
+	defer worker.StopWorker()
+
+	first := <-executions
+	select {
+	case second := <-executions:
+		if elapsed := second.Sub(first); elapsed < 200*time.Millisecond {
+			t.Errorf("task was retried after %v before its countdown", elapsed)
+		}
+	case <-time.After(TIMEOUT):
+		t.Errorf("task was not retried")
+	}
+}
+
+// TestWorkerStopRequeuesHeldTask tests that message held until ETA is sent back to broker on stop
+func TestWorkerStopRequeuesHeldTask(t *testing.T) {
+	broker := &queueBroker{}
+	worker := NewCeleryWorker(broker, NewMemoryCeleryBackend(), 1)
+	worker.Register("add", add)
+	message := getTaskMessage(context.Background(), "add")
+	message.Args = []interface{}{1, 2}
+	eta := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	message.ETA = &eta
+	if err := sendTaskMessage(context.Background(), broker, TIMEOUT, message); err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	worker.StartWorker(context.Background(), TIMEOUT)
+	for deadline := time.Now().Add(TIMEOUT); len(broker.queued()) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	worker.StopWorker()
+
+	if queued := broker.queued(); !reflect.DeepEqual(queued, []string{message.ID}) {
+		t.Errorf("expected held message %s to be requeued, got %v", message.ID, queued)
+	}
+	if _, err := worker.backend.GetResult(context.Background(), message.ID); err == nil {
+		t.Errorf("task should not run before its ETA")
+	}
+}
+
+// contextBackend stores results in memory failing on cancelled context like network backends
+type contextBackend struct {
+	*MemoryCeleryBackend
+}
+
+func (b *contextBackend) SetResult(ctx context.Context, taskID string, result *ResultMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.MemoryCeleryBackend.SetResult(ctx, taskID, result)
+}
+
+// TestWorkerStopStoresResult tests that result of task finishing while worker stops is stored
+func TestWorkerStopStoresResult(t *testing.T) {
+	broker := &queueBroker{}
+	backend := &contextBackend{NewMemoryCeleryBackend()}
+	cli, _ := NewCeleryClient(broker, backend, 1)
+	started := make(chan struct{})
+	cli.Register("wait", func(ctx context.Context) string {
+		close(started)
+		<-ctx.Done()
+		return "stopped"
+	})
+	ctx := context.Background()
+	asyncResult, err := cli.Delay(ctx, TIMEOUT, "wait")
+	if err != nil {
+		t.Fatalf("failed to send task: %v", err)
+	}
+	cli.StartWorker(ctx, TIMEOUT)
+	<-started
+	cli.StopWorker()
+
+	res, err := asyncResult.Get(ctx, TIMEOUT)
+	if err != nil || res != "stopped" {
+		t.Errorf("expected result of stopped task, got %v (%v)", res, err)
+	}
+}
+
+// TestWorkerShutdownRequeue tests returning message fetched during shutdown to broker
+func TestWorkerShutdownRequeue(t *testing.T) {
+	late := getTaskMessage(context.Background(), "add")
+	late.Args = []interface{}{1, 2}
+	broker := &queueBroker{late: late}
+	backend := NewMemoryCeleryBackend()
+	worker := NewCeleryWorker(broker, backend, 1)
+	worker.Register("add", add)
+	worker.StartWorker(context.Background(), TIMEOUT)
+	time.Sleep(10 * time.Millisecond)
+
+	if err := worker.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if queued := broker.queued(); !reflect.DeepEqual(queued, []string{late.ID}) {
+		t.Errorf("expected message %s to be requeued, got %v", late.ID, queued)
+	}
+	if _, err := backend.GetResult(context.Background(), late.ID); err == nil {
+		t.Errorf("requeued message should not have been executed")
+	}
+}
+
+// prefetchBroker records prefetch count set by worker
+type prefetchBroker struct {
+	queueBroker
+	count int
+}
+
+func (b *prefetchBroker) SetPrefetchCount(count int) error {
+	b.count = count
+	return nil
+}
+
+// TestWorkerPrefetchMultiplier tests prefetch count configured on broker
+func TestWorkerPrefetchMultiplier(t *testing.T) {
+	testCases := []struct {
+		name       string
+		multiplier int
+		autoscale  *Autoscale
+		expected   int
+	}{
+		{name: "default prefetch", multiplier: 0, expected: 0},
+		{name: "multiplier", multiplier: 4, expected: 12},
+		{name: "autoscale", multiplier: 2, autoscale: &Autoscale{Min: 1, Max: 5, Interval: time.Hour}, expected: 10},
+	}
+	for _, tc := range testCases {
+		broker := &prefetchBroker{}
+		worker := NewCeleryWorker(broker, NewMemoryCeleryBackend(), 3)
+		worker.SetPrefetchMultiplier(tc.multiplier)
+		if tc.autoscale != nil {
+			worker.SetAutoscale(*tc.autoscale)
+		}
+		worker.StartWorker(context.Background(), TIMEOUT)
+		worker.StopWorker()
+		if broker.count != tc.expected {
+			t.Errorf("test '%s': expected prefetch count %d, got %d", tc.name, tc.expected, broker.count)
+		}
+	}
+}
+
+// TestWorkerUnregisteredTaskPolicy tests handling of messages of unregistered tasks
+func TestWorkerUnregisteredTaskPolicy(t *testing.T) {
+	testCases := []struct {
+		name         string
+		policy       UnregisteredTaskPolicy
+		noDLQ        bool
+		delay        time.Duration
+		deadLettered bool
+		status       string
+	}{
+		{name: "default", policy: UnregisteredTaskPolicy{}, delay: defaultUnregisteredTaskDelay},
+		{name: "requeue with delay", policy: UnregisteredTaskPolicy{Action: UnregisteredTaskRequeue, Delay: time.Minute}, delay: time.Minute},
+		{name: "reject", policy: UnregisteredTaskPolicy{Action: UnregisteredTaskReject}, deadLettered: true},
+		{name: "reject without dead letter queue", policy: UnregisteredTaskPolicy{Action: UnregisteredTaskReject}, noDLQ: true, status: "FAILURE"},
+		{name: "fail", policy: UnregisteredTaskPolicy{Action: UnregisteredTaskFail}, status: "FAILURE"},
+	}
+	for _, tc := range testCases {
+		ctx := context.Background()
+		broker := &captureBroker{}
+		backend := NewMemoryCeleryBackend()
+		dlq := NewMemoryDeadLetterQueue()
+		worker := NewCeleryWorker(broker, backend, 1)
+		worker.SetLogger(NopLogger())
+		if !tc.noDLQ {
+			worker.SetDeadLetterQueue(dlq)
+		}
+		worker.SetUnregisteredTaskPolicy(tc.policy)
+
+		message := getTaskMessage(ctx, "unknown")
+		message.Args = []interface{}{1}
+		sent := time.Now()
+		worker.processTask(ctx, ctx, message)
+
+		// requeued message is kept by broker until its ETA
+		requeued := len(broker.messages) == 1 && broker.messages[0].ID == message.ID
+		if requeued != (tc.delay > 0) {
+			t.Errorf("test '%s': expected requeued %v, got messages %+v", tc.name, tc.delay > 0, broker.messages)
+		} else if requeued {
+			eta, ok := broker.messages[0].etaTime()
+			if !ok || eta.Before(sent.Add(tc.delay)) || eta.After(time.Now().Add(tc.delay)) {
+				t.Errorf("test '%s': expected ETA delayed by %v, got %v", tc.name, tc.delay, broker.messages[0].ETA)
+			}
+		}
+		deadLetters, _ := dlq.List(ctx)
+		if deadLettered := len(deadLetters) == 1; deadLettered != tc.deadLettered {
+			t.Errorf("test '%s': expected dead-lettered %v, got %+v", tc.name, tc.deadLettered, deadLetters)
+		}
+		result, err := backend.GetResult(ctx, message.ID)
+		if tc.status == "" {
+			if err == nil {
+				t.Errorf("test '%s': expected no result, got %+v", tc.name, result)
+			}
+			continue
+		}
+		if err != nil || result.Status != tc.status {
+			t.Errorf("test '%s': expected %s result, got %+v (%v)", tc.name, tc.status, result, err)
+			continue
+		}
+		if excInfo, _ := result.Result.(map[string]interface{}); excInfo["exc_type"] != "NotRegistered" {
+			t.Errorf("test '%s': expected NotRegistered exception, got %v", tc.name, result.Result)
+		}
+	}
+}